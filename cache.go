@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+const defaultCacheTTL = 24 * time.Hour
+
+func getCacheDir() string {
+	return filepath.Join(getAppDir(), "cache")
+}
+
+func newResponseCache() *assembllm.ResponseCache {
+	return &assembllm.ResponseCache{Dir: getCacheDir(), TTL: appCfg.CacheTTL}
+}
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached responses",
+	}
+
+	clearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Remove cached responses",
+		Args:  cobra.NoArgs,
+		RunE:  clearCache,
+	}
+	clearCmd.Flags().Bool("expired", false, "Only remove responses older than the TTL")
+	clearCmd.Flags().DurationVarP(&appCfg.CacheTTL, "cache-ttl", "", defaultCacheTTL, "How long cached responses are used")
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the number and size of cached responses",
+		Args:  cobra.NoArgs,
+		RunE:  showCacheStats,
+	}
+	statsCmd.Flags().DurationVarP(&appCfg.CacheTTL, "cache-ttl", "", defaultCacheTTL, "How long cached responses are used")
+
+	cmd.AddCommand(clearCmd, statsCmd)
+
+	for _, c := range cmd.Commands() {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+	}
+
+	return cmd
+}
+
+func clearCache(cmd *cobra.Command, args []string) error {
+	expired, _ := cmd.Flags().GetBool("expired")
+
+	removed, err := newResponseCache().Clear(expired)
+	if err != nil {
+		return fmt.Errorf("error clearing cache: %v", err)
+	}
+
+	fmt.Printf("Removed %d cached responses\n", removed)
+	return nil
+}
+
+func showCacheStats(cmd *cobra.Command, args []string) error {
+	stats, err := newResponseCache().Stats()
+	if err != nil {
+		return fmt.Errorf("error reading cache: %v", err)
+	}
+
+	fmt.Printf("Location: %s\n", getCacheDir())
+	fmt.Printf("Responses: %d (%d expired)\n", stats.Entries, stats.Expired)
+	fmt.Printf("Size: %.1f KB\n", float64(stats.Bytes)/1024)
+	if stats.Entries > 0 {
+		fmt.Printf("Oldest: %s\n", stats.Oldest.Local().Format(time.DateTime))
+		fmt.Printf("Newest: %s\n", stats.Newest.Local().Format(time.DateTime))
+	}
+
+	return nil
+}
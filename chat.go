@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+)
+
+const chatHelp = `Commands:
+  /model [name]    Switch model, choose from a list when no name is given
+  /plugin [name]   Switch plugin, choose from a list when no name is given
+  /role [role]     Set the role, clears it when no role is given
+  /save <path>     Save the conversation history as JSON
+  /clear           Clear the conversation history
+  /help            Show this help
+  /exit            Leave the chat
+`
+
+type chatSession struct {
+	client    *assembllm.Client
+	pluginCfg assembllm.CompletionPluginConfig
+	messages  []assembllm.Message
+	stored    *session
+}
+
+func newChatCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "chat [prompt]",
+		Short:         "Start an interactive multi-turn chat",
+		Args:          cobra.MaximumNArgs(1),
+		RunE:          runChat,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&appCfg.Name, "plugin", "p", "", "The name of the plugin to use")
+	flags.BoolVarP(&appCfg.ChoosePlugin, "choose-plugin", "P", false, "Choose the plugin to use")
+	flags.StringVarP(&appCfg.Model, "model", "m", "", "The name of the model to use")
+	flags.BoolVarP(&appCfg.ChooseAIModel, "choose-model", "M", false, "Choose the model to use")
+	flags.StringVarP(&appCfg.Temperature, "temperature", "t", "", "The temperature to use")
+	flags.StringVarP(&appCfg.Role, "role", "r", "", "The role to use")
+	flags.BoolVarP(&appCfg.Raw, "raw", "", false, "Raw output without formatting")
+	flags.StringVarP(&appCfg.Session, "session", "s", "", "Resume and save the named conversation session")
+	flags.DurationVarP(&appCfg.Timeout, "timeout", "", 0, "Limit on each plugin call, e.g. 30s or 2m")
+	flags.BoolVarP(&appCfg.Usage, "usage", "", false, "Print token usage and cost when the chat ends")
+	flags.SortFlags = false
+
+	return cmd
+}
+
+func runChat(cmd *cobra.Command, args []string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if appCfg.ChoosePlugin {
+		appCfg.Name, err = choosePlugin()
+		if err != nil {
+			return err
+		}
+	}
+
+	pluginCfg, err := client.Plugin(appCfg.Name)
+	if err != nil {
+		return err
+	}
+	pluginCfg = overridePluginConfigWithUserFlags(appCfg, pluginCfg)
+
+	if appCfg.ChooseAIModel {
+		pluginCfg.Model, err = chooseModel(pluginCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	chat := &chatSession{
+		client:    client,
+		pluginCfg: pluginCfg,
+	}
+
+	if appCfg.Session != "" {
+		chat.stored, err = loadSession(appCfg.Session)
+		if err != nil {
+			return err
+		}
+		chat.messages = chat.stored.Messages
+		fmt.Printf("Resuming session %s with %d messages.\n", appCfg.Session, len(chat.messages))
+	}
+
+	fmt.Printf("Chatting with %s, type /help for commands.\n", chat.pluginCfg.Name)
+
+	var prompt string
+	if len(args) == 1 {
+		prompt = args[0]
+	}
+
+	for {
+		if prompt == "" {
+			err := huh.NewInput().
+				Title(">").
+				Inline(true).
+				Value(&prompt).
+				WithTheme(huh.ThemeCharm()).
+				Run()
+			if errors.Is(err, huh.ErrUserAborted) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error getting input: %v", err)
+			}
+		}
+
+		prompt = strings.TrimSpace(prompt)
+		if prompt == "" {
+			continue
+		}
+
+		if strings.HasPrefix(prompt, "/") {
+			exit, err := chat.handleCommand(prompt)
+			if err != nil {
+				fmt.Println(err)
+			}
+			if exit {
+				return nil
+			}
+		} else if err := chat.send(cmd.Context(), prompt); err != nil {
+			fmt.Println(err)
+		}
+
+		if err := cmd.Context().Err(); err != nil {
+			return err
+		}
+
+		prompt = ""
+	}
+}
+
+// Sends the prompt with the conversation history and prints the reply
+// Quitting the spinner cancels the reply and returns to the prompt
+func (s *chatSession) send(ctx context.Context, prompt string) error {
+	fmt.Println("> " + prompt)
+
+	messages := append(s.messages, assembllm.Message{Role: "user", Content: prompt})
+
+	res, streamed, err := generateWithStreaming(ctx, s.pluginCfg, true, func(ctx context.Context, pc assembllm.CompletionPluginConfig) (string, error) {
+		return pc.GenerateChatResponseContext(ctx, messages)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.messages = append(messages, assembllm.Message{Role: "assistant", Content: res})
+	if err := s.store(); err != nil {
+		return err
+	}
+
+	if streamed {
+		fmt.Println()
+	} else {
+		fmt.Println(formatResponse(res, appCfg.Raw))
+	}
+
+	return nil
+}
+
+// Handles a slash command, returning true when the chat should end
+func (s *chatSession) handleCommand(line string) (bool, error) {
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch command {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		fmt.Print(chatHelp)
+	case "/clear":
+		s.messages = nil
+		if err := s.store(); err != nil {
+			return false, err
+		}
+		fmt.Println("Conversation cleared.")
+	case "/role":
+		s.pluginCfg.Role = arg
+		fmt.Printf("Role set to %q.\n", arg)
+	case "/model":
+		model := arg
+		if model == "" {
+			var err error
+			model, err = chooseModel(s.pluginCfg)
+			if err != nil {
+				return false, err
+			}
+		}
+		s.pluginCfg.Model = model
+		fmt.Printf("Model set to %s.\n", model)
+	case "/plugin":
+		name := arg
+		if name == "" {
+			var err error
+			name, err = choosePlugin()
+			if err != nil {
+				return false, err
+			}
+		}
+		pluginCfg, err := s.client.Plugin(name)
+		if err != nil {
+			return false, err
+		}
+		pluginCfg.Role = s.pluginCfg.Role
+		pluginCfg.Temperature = s.pluginCfg.Temperature
+		s.pluginCfg = pluginCfg
+		fmt.Printf("Plugin set to %s.\n", name)
+	case "/save":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /save <path>")
+		}
+		data, err := json.MarshalIndent(s.messages, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(arg, data, 0600); err != nil {
+			return false, fmt.Errorf("error saving conversation: %v", err)
+		}
+		fmt.Printf("Conversation saved to %s.\n", arg)
+	default:
+		return false, fmt.Errorf("unknown command: %s, type /help for commands", command)
+	}
+
+	return false, nil
+}
+
+// Saves the conversation when the chat was started with a session
+func (s *chatSession) store() error {
+	if s.stored == nil {
+		return nil
+	}
+
+	s.stored.Messages = s.messages
+	s.stored.Plugin = s.pluginCfg.Name
+	s.stored.Model = s.pluginCfg.Model
+	if err := s.stored.save(); err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestHandleCommand(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+
+	client := assembllm.NewClient(assembllm.CompletionPluginConfigs{Plugins: []assembllm.CompletionPluginConfig{
+		{Name: "openai", Model: "gpt-4o"},
+		{Name: "anthropic", Model: "claude-3-haiku"},
+	}})
+	messages := []assembllm.Message{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "hi there"},
+	}
+
+	tests := []struct {
+		name    string
+		line    string
+		exit    bool
+		wantErr string
+		check   func(t *testing.T, s *chatSession)
+	}{
+		{name: "exit", line: "/exit", exit: true},
+		{name: "quit", line: "/quit", exit: true},
+		{name: "help", line: "/help"},
+		{name: "clear", line: "/clear", check: func(t *testing.T, s *chatSession) {
+			if len(s.messages) != 0 {
+				t.Fatalf("want no messages, got %v", s.messages)
+			}
+			// The cleared conversation is saved to the session
+			stored, err := loadSession("work")
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if len(stored.Messages) != 0 || stored.Plugin != "openai" {
+				t.Fatalf("want an empty openai session, got %+v", stored)
+			}
+		}},
+		{name: "role", line: "/role  be brief ", check: func(t *testing.T, s *chatSession) {
+			if s.pluginCfg.Role != "be brief" {
+				t.Fatalf("want %q, got %q", "be brief", s.pluginCfg.Role)
+			}
+		}},
+		{name: "model", line: "/model gpt-4o-mini", check: func(t *testing.T, s *chatSession) {
+			if s.pluginCfg.Model != "gpt-4o-mini" {
+				t.Fatalf("want %q, got %q", "gpt-4o-mini", s.pluginCfg.Model)
+			}
+		}},
+		{name: "plugin", line: "/plugin anthropic", check: func(t *testing.T, s *chatSession) {
+			// The role and temperature carry over to the new plugin
+			if s.pluginCfg.Name != "anthropic" || s.pluginCfg.Model != "claude-3-haiku" || s.pluginCfg.Role != "be terse" || s.pluginCfg.Temperature != "0.5" {
+				t.Fatalf("want anthropic with the role and temperature kept, got %+v", s.pluginCfg)
+			}
+			if !reflect.DeepEqual(s.messages, messages) {
+				t.Fatalf("want messages %v, got %v", messages, s.messages)
+			}
+		}},
+		{name: "unknown plugin", line: "/plugin missing", wantErr: "plugin not found: missing"},
+		{name: "save", line: "/save " + filepath.Join(dir, "chat.json"), check: func(t *testing.T, s *chatSession) {
+			data, err := os.ReadFile(filepath.Join(dir, "chat.json"))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			var saved []assembllm.Message
+			if err := json.Unmarshal(data, &saved); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if !reflect.DeepEqual(saved, messages) {
+				t.Fatalf("want messages %v, got %v", messages, saved)
+			}
+		}},
+		{name: "save without path", line: "/save", wantErr: "usage: /save <path>"},
+		{name: "save to missing dir", line: "/save " + filepath.Join(dir, "missing", "chat.json"), wantErr: "error saving conversation"},
+		{name: "unknown", line: "/nope", wantErr: "unknown command: /nope, type /help for commands"},
+		{name: "unknown with arg", line: "/exits now", wantErr: "unknown command: /exits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCfg, err := client.Plugin("openai")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pluginCfg.Role = "be terse"
+			pluginCfg.Temperature = "0.5"
+			s := &chatSession{
+				client:    client,
+				pluginCfg: pluginCfg,
+				messages:  append([]assembllm.Message{}, messages...),
+				stored:    &session{Name: "work"},
+			}
+
+			exit, err := s.handleCommand(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("want %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if exit != tt.exit {
+				t.Fatalf("want exit %v, got %v", tt.exit, exit)
+			}
+			if tt.check != nil {
+				tt.check(t, s)
+			}
+		})
+	}
+}
@@ -52,7 +52,7 @@ func TestGetModels(t *testing.T) {
 		t.Fatalf("expected nil, got %v", err)
 	}
 
-	models, err := pluginCfg.getModels()
+	models, err := pluginCfg.GetModels()
 	if err != nil {
 		t.Fatalf("expected nil, got %v", err)
 	}
@@ -74,7 +74,7 @@ func TestGetResponse(t *testing.T) {
 		t.Fatalf("expected nil, got %v", err)
 	}
 
-	_, err = pluginCfg.generateResponse("hello", false)
+	_, err = pluginCfg.GenerateResponse("hello")
 	if err != nil {
 		t.Fatalf("expected nil, got %v", err)
 	}
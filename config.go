@@ -1,41 +1,29 @@
 package main
 
 import (
+	"bytes"
 	_ "embed"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
-	extism "github.com/extism/go-sdk"
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"gopkg.in/yaml.v3"
 )
 
-const configFileName = "config.yaml"
+const (
+	configFileName = "config.yaml"
+	configEnvVar   = "ASSEMBLLM_CONFIG"
+)
 
 var (
 	//go:embed config.yaml
 	defaultConfig []byte
 )
 
-type CompletionPluginConfig struct {
-	Name        string `yaml:"name"`
-	Source      string `yaml:"source"`
-	Hash        string `yaml:"hash"`
-	APIKey      string `yaml:"apiKey"`
-	AccountId   string `yaml:"accountId"`
-	URL         string `yaml:"url"`
-	Model       string `yaml:"model"`
-	Temperature string `yaml:"temperature"`
-	Role        string `yaml:"role"`
-	Wasi        bool   `yaml:"wasi"`
-	LogLevel    extism.LogLevel
-}
-
-type CompletionPluginConfigs struct {
-	Plugins []CompletionPluginConfig `yaml:"completion-plugins"`
-}
-
 type Config struct {
-	CompletionPluginConfigs CompletionPluginConfigs `yaml:"completion-plugins"`
+	CompletionPluginConfigs assembllm.CompletionPluginConfigs `yaml:"completion-plugins"`
 }
 
 func createConfig(configPath string) {
@@ -68,27 +56,258 @@ func writeConfig(configDataUpdates []byte, configPath string) {
 	}
 }
 
-func getConfigPath() string {
+// Gets the directory holding the default config file, sessions, caches, and the usage ledger
+func getAppDir() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("Unable to get user's home directory: %v", err)
 	}
 
-	configPath := filepath.Join(homeDir, "."+appName, configFileName)
-	return configPath
+	return filepath.Join(homeDir, "."+appName)
+}
+
+// Gets the path of the user's config file, set with --config or ASSEMBLLM_CONFIG
+func getConfigPath() string {
+	if appCfg.ConfigPath != "" {
+		return appCfg.ConfigPath
+	}
+	if path := os.Getenv(configEnvVar); path != "" {
+		return path
+	}
+
+	return filepath.Join(getAppDir(), configFileName)
 }
 
-func setupConfig() {
+// Creates the default config file, or migrates an existing one
+// A config file given with --config or ASSEMBLLM_CONFIG must already exist
+func setupConfig() error {
 	configPath := getConfigPath()
 
 	// Check if the configuration file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if configPath != filepath.Join(getAppDir(), configFileName) {
+			return configError(fmt.Errorf("config file not found: %s", configPath))
+		}
 		createConfig(configPath)
 	} else {
 		configData := readConfig(configPath)
 
-		configDataUpdates := updatePlugins(configData)
+		configDataUpdates, version, changed, err := migrateConfig(configData)
+		if err != nil {
+			log.Fatalf("Unable to migrate config file: %v", err)
+		}
+		if changed {
+			// Keep the previous version in case a migration needs to be undone
+			writeConfig(configData, fmt.Sprintf("%s.v%d.bak", configPath, version))
+			writeConfig(configDataUpdates, configPath)
+		}
+	}
+
+	return nil
+}
+
+// Loads the available chat completion plugins from a yaml file
+func getAvailablePlugins(filename string) (assembllm.CompletionPluginConfigs, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return assembllm.CompletionPluginConfigs{}, err
+	}
+	defer file.Close()
+
+	return assembllm.LoadPluginConfigs(file)
+}
+
+// Gets the available plugins from the yaml file, then gets the plugin config for the specified plugin
+func getPluginConfig(pluginName string, configPath string) (assembllm.CompletionPluginConfig, error) {
+	pluginConfigs, err := getAvailablePlugins(configPath)
+	if err != nil {
+		return assembllm.CompletionPluginConfig{}, fmt.Errorf("failed to get config from yaml: %v", err)
+	}
+
+	pluginCfg, err := pluginConfigs.GetPlugin(pluginName)
+	if err != nil {
+		return assembllm.CompletionPluginConfig{}, fmt.Errorf("failed to get plugin info: %v", err)
+	}
+	pluginCfg.LogLevel = logLevel
+
+	return pluginCfg, nil
+}
+
+// Creates a library client from the user's plugin configuration, merged with the project config and profile
+func newClient() (*assembllm.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client := assembllm.NewClient(cfg.Plugins)
+	client.LogLevel = logLevel
+	client.Timeout = appCfg.Timeout
+	client.OnUsage = runUsage.add
+	client.Cache = newResponseCache()
+	client.CacheAll = appCfg.Cache
+	client.ModuleDir = filepath.Join(getAppDir(), "modules")
+
+	return client, nil
+}
+
+// The parsed configuration file, edited as yaml nodes so comments and environment variable names are kept
+type configDocument struct {
+	path    string
+	root    yaml.Node
+	plugins *yaml.Node
+}
+
+func loadConfigDocument(path string) (*configDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %v", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to get config from yaml: %v", err)
+	}
+
+	doc, err := newConfigDocument(root)
+	if err != nil {
+		return nil, err
+	}
+	doc.path = path
+
+	return doc, nil
+}
+
+func newConfigDocument(root yaml.Node) (*configDocument, error) {
+	if len(root.Content) == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to get config from yaml: config is not a mapping")
+	}
+
+	doc := &configDocument{root: root}
+	doc.plugins = mappingValue(doc.mapping(), "completion-plugins")
+	if doc.plugins == nil {
+		doc.plugins = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(doc.mapping(), "completion-plugins", doc.plugins)
+	}
+	if doc.plugins.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("failed to get config from yaml: completion-plugins is not a list")
+	}
+
+	return doc, nil
+}
+
+func (doc *configDocument) mapping() *yaml.Node {
+	return doc.root.Content[0]
+}
+
+// Gets the version of the configuration, configurations from before versioning are version 0
+func (doc *configDocument) version() (int, error) {
+	node := mappingValue(doc.mapping(), "version")
+	if node == nil {
+		return 0, nil
+	}
+
+	var version int
+	if err := node.Decode(&version); err != nil {
+		return 0, fmt.Errorf("invalid config version: %s", node.Value)
+	}
+	return version, nil
+}
+
+// Sets the version, adding it at the top of the configuration when missing
+func (doc *configDocument) setVersion(version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(version)}
+	if mappingValue(doc.mapping(), "version") != nil {
+		setMappingValue(doc.mapping(), "version", value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	doc.mapping().Content = append([]*yaml.Node{key, value}, doc.mapping().Content...)
+}
+
+func (doc *configDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc.root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (doc *configDocument) save() error {
+	data, err := doc.encode()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(doc.path, data, 0600)
+}
 
-		writeConfig(configDataUpdates, configPath)
+// Gets the names of the configured plugins, or checks the given names are configured
+func (doc *configDocument) pluginNames(names []string) ([]string, error) {
+	if len(names) > 0 {
+		for _, name := range names {
+			if _, node := doc.plugin(name); node == nil {
+				return nil, fmt.Errorf("plugin not found: %s", name)
+			}
+		}
+		return names, nil
 	}
+
+	for _, node := range doc.plugins.Content {
+		names = append(names, scalarValue(node, "name"))
+	}
+	return names, nil
+}
+
+// Gets the index and node of the named plugin, the node is nil when it isn't configured
+func (doc *configDocument) plugin(name string) (int, *yaml.Node) {
+	for i, node := range doc.plugins.Content {
+		if scalarValue(node, "name") == name {
+			return i, node
+		}
+	}
+	return -1, nil
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarValue(mapping *yaml.Node, key string) string {
+	if node := mappingValue(mapping, key); node != nil && node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	return ""
+}
+
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func setScalar(mapping *yaml.Node, key string, value string) {
+	setMappingValue(mapping, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
 }
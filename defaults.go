@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// The plugin used when no flag, environment variable, profile, or config file sets one
+	defaultPluginName = "openai"
+
+	// Prefix of the environment variables that set defaults, e.g. ASSEMBLLM_MODEL
+	defaultsEnvPrefix = "ASSEMBLLM_"
+)
+
+// An effective setting and where it was set
+type setting struct {
+	Value  string
+	Source string
+}
+
+// The effective plugin, model, role, temperature, and raw mode
+type resolvedDefaults struct {
+	Plugin      setting
+	Model       setting
+	Role        setting
+	Temperature setting
+	Raw         setting
+}
+
+// Resolves the defaults for a command, in order of precedence: flags, environment variables,
+// the selected profile, the project config, and the user config
+func resolveDefaults(cmd *cobra.Command, cfg *resolvedConfig) resolvedDefaults {
+	pluginOf := func(d defaults) string { return d.Plugin }
+
+	var d resolvedDefaults
+	d.Plugin = resolveSetting(cmd, "plugin", cfg.Layers, pluginOf)
+	if changedFlag(cmd, "choose-plugin") {
+		d.Plugin = setting{Source: "flag --choose-plugin"}
+	}
+	if d.Plugin.Source == "" {
+		d.Plugin = setting{Value: defaultPluginName, Source: "default"}
+	}
+
+	// A model in config is for the plugin chosen there, not one chosen with a flag or environment variable
+	modelLayers := cfg.Layers
+	configPlugin := layeredSetting(cfg.Layers, pluginOf).Value
+	if configPlugin == "" {
+		configPlugin = defaultPluginName
+	}
+	if configPlugin != d.Plugin.Value {
+		modelLayers = nil
+	}
+
+	d.Model = resolveSetting(cmd, "model", modelLayers, func(d defaults) string { return d.Model })
+	d.Role = resolveSetting(cmd, "role", cfg.Layers, func(d defaults) string { return d.Role })
+	d.Temperature = resolveSetting(cmd, "temperature", cfg.Layers, func(d defaults) string { return d.Temperature })
+	d.Raw = resolveSetting(cmd, "raw", cfg.Layers, func(d defaults) string {
+		if d.Raw == nil {
+			return ""
+		}
+		return strconv.FormatBool(*d.Raw)
+	})
+
+	return d
+}
+
+// Gets a setting from the command's flag, then its environment variable, then the config layers
+func resolveSetting(cmd *cobra.Command, name string, layers []defaultsLayer, get func(defaults) string) setting {
+	if changedFlag(cmd, name) {
+		return setting{Value: cmd.Flags().Lookup(name).Value.String(), Source: "flag --" + name}
+	}
+
+	env := defaultsEnvPrefix + strings.ToUpper(name)
+	if value := os.Getenv(env); value != "" {
+		return setting{Value: value, Source: "env " + env}
+	}
+
+	return layeredSetting(layers, get)
+}
+
+// Gets a setting from the highest precedence config layer that sets it
+func layeredSetting(layers []defaultsLayer, get func(defaults) string) setting {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if value := get(layers[i].Defaults); value != "" {
+			return setting{Value: value, Source: layers[i].Source}
+		}
+	}
+	return setting{}
+}
+
+func changedFlag(cmd *cobra.Command, name string) bool {
+	if cmd == nil {
+		return false
+	}
+	flag := cmd.Flags().Lookup(name)
+	return flag != nil && flag.Changed
+}
+
+// Sets the plugin, model, role, temperature, and raw mode for commands that take them
+func applyDefaults(cmd *cobra.Command) error {
+	if cmd.Flags().Lookup("plugin") == nil || appCfg.Version {
+		return nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	d := resolveDefaults(cmd, cfg)
+	raw := false
+	if d.Raw.Value != "" {
+		if raw, err = strconv.ParseBool(d.Raw.Value); err != nil {
+			return configError(fmt.Errorf("invalid raw value %q from %s", d.Raw.Value, d.Raw.Source))
+		}
+	}
+
+	appCfg.Name = d.Plugin.Value
+	appCfg.Model = d.Model.Value
+	appCfg.Role = d.Role.Value
+	appCfg.Temperature = d.Temperature.Value
+	appCfg.Raw = raw
+
+	return nil
+}
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show the configuration",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the config merged from the user config, project config, and profile",
+		Args:  cobra.NoArgs,
+		RunE:  showConfig,
+	}
+	showCmd.Flags().Bool("resolved", false, "Show the effective defaults and where each was set")
+
+	cmd.AddCommand(showCmd)
+
+	for _, c := range cmd.Commands() {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+	}
+
+	return cmd
+}
+
+func showConfig(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if resolved, _ := cmd.Flags().GetBool("resolved"); !resolved {
+		out, err := cfg.merged.encode()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	}
+
+	d := resolveDefaults(cmd, cfg)
+
+	// Settings not given a default come from the plugin's own config
+	var plugin assembllm.CompletionPluginConfig
+	for _, p := range cfg.Plugins.Plugins {
+		if p.Name == d.Plugin.Value {
+			plugin = p
+		}
+	}
+	fromPlugin := func(s setting, value string) setting {
+		if s.Value != "" || value == "" {
+			return s
+		}
+		return setting{Value: value, Source: "plugin " + plugin.Name}
+	}
+	d.Model = fromPlugin(d.Model, plugin.Model)
+	d.Role = fromPlugin(d.Role, plugin.Role)
+	d.Temperature = fromPlugin(d.Temperature, plugin.Temperature)
+	if d.Raw.Value == "" {
+		d.Raw = setting{Value: "false", Source: "default"}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range []struct {
+		name string
+		setting
+	}{
+		{"plugin", d.Plugin},
+		{"model", d.Model},
+		{"role", d.Role},
+		{"temperature", d.Temperature},
+		{"raw", d.Raw},
+	} {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.name, s.Value, s.Source)
+	}
+
+	return tw.Flush()
+}
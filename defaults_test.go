@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestResolveDefaults(t *testing.T) {
+	raw := true
+	cfg := &resolvedConfig{Layers: []defaultsLayer{
+		{Source: "user config", Defaults: defaults{Plugin: "anthropic", Model: "claude-3-haiku", Role: "be brief", Temperature: "0.2"}},
+		{Source: "project config", Defaults: defaults{Model: "claude-3-opus", Raw: &raw}},
+	}}
+
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().StringP("plugin", "p", "", "")
+		cmd.Flags().StringP("temperature", "t", "", "")
+		return cmd
+	}
+
+	t.Setenv("ASSEMBLLM_ROLE", "be thorough")
+	cmd := newCmd()
+	cmd.Flags().Set("temperature", "0.9")
+
+	d := resolveDefaults(cmd, cfg)
+	want := resolvedDefaults{
+		Plugin:      setting{"anthropic", "user config"},
+		Model:       setting{"claude-3-opus", "project config"},
+		Role:        setting{"be thorough", "env ASSEMBLLM_ROLE"},
+		Temperature: setting{"0.9", "flag --temperature"},
+		Raw:         setting{"true", "project config"},
+	}
+	if d != want {
+		t.Fatalf("want %+v, got %+v", want, d)
+	}
+
+	// A model in config doesn't carry over to a plugin chosen with a flag
+	cmd = newCmd()
+	cmd.Flags().Set("plugin", "openai")
+	if d := resolveDefaults(cmd, cfg); d.Model.Value != "" {
+		t.Fatalf("want no model, got %s from %s", d.Model.Value, d.Model.Source)
+	}
+
+	if d := resolveDefaults(newCmd(), &resolvedConfig{}); d.Plugin != (setting{defaultPluginName, "default"}) {
+		t.Fatalf("want %s, got %+v", defaultPluginName, d.Plugin)
+	}
+}
@@ -5,6 +5,7 @@ go 1.22.3
 require (
 	github.com/charmbracelet/lipgloss v0.11.0
 	github.com/extism/go-sdk v1.2.0
+	github.com/mattn/go-isatty v0.0.20
 	github.com/yuin/goldmark v1.5.4
 )
 
@@ -29,7 +30,6 @@ require (
 	github.com/itchyny/gojq v0.12.13 // indirect
 	github.com/itchyny/timefmt-go v0.1.5 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-localereader v0.0.1 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/microcosm-cc/bluemonday v1.0.25 // indirect
@@ -57,6 +57,6 @@ require (
 	github.com/expr-lang/expr v1.16.9
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/spf13/cobra v1.8.0
-	github.com/tetratelabs/wazero v1.3.0 // indirect
+	github.com/tetratelabs/wazero v1.3.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -2,12 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/huh/spinner"
 	"github.com/charmbracelet/lipgloss"
@@ -21,25 +28,37 @@ type App struct {
 }
 
 type AppConfig struct {
-	Name                  string
-	Model                 string
-	ChooseAIModel         bool
-	ChoosePlugin          bool
-	ChooseWorkflow        bool
-	Temperature           string
-	Role                  string
-	Raw                   bool
-	Version               bool
-	WorkflowPath          string
-	IteratorPrompt        bool
-	CurrentIterationValue interface{}
-	Feedback              bool
+	Name           string
+	Model          string
+	ChooseAIModel  bool
+	ChoosePlugin   bool
+	ChooseWorkflow bool
+	Temperature    string
+	Role           string
+	Raw            bool
+	Version        bool
+	WorkflowPath   string
+	Inputs         []string
+	InputFile      string
+	IteratorPrompt bool
+	Feedback       bool
+	Session        string
+	Parallel       int
+	Timeout        time.Duration
+	Output         string
+	Usage          bool
+	Cache          bool
+	CacheTTL       time.Duration
+	ConfigPath     string
+	Profile        string
 }
 
 const (
 	version = "0.7.0"
 )
 
+var errInterrupted = errors.New("interrupted")
+
 var (
 	appCfg   AppConfig
 	logLevel = extism.LogLevelOff
@@ -47,8 +66,8 @@ var (
 )
 
 // Gets the available models from the completions plugin and prompts the user to choose one
-func chooseModel(pluginCfg CompletionPluginConfig) (string, error) {
-	modelNames, err := pluginCfg.getModels()
+func chooseModel(pluginCfg assembllm.CompletionPluginConfig) (string, error) {
+	modelNames, err := pluginCfg.GetModels()
 	if err != nil {
 		return "", fmt.Errorf("failed to get models: %v", err)
 	}
@@ -78,7 +97,7 @@ func initializeFlags(app *App) {
 	app.RootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
 
 	flags := app.RootCmd.Flags()
-	flags.StringVarP(&appCfg.Name, "plugin", "p", "openai", "The name of the plugin to use")
+	flags.StringVarP(&appCfg.Name, "plugin", "p", "", "The name of the plugin to use")
 	flags.BoolVarP(&appCfg.ChoosePlugin, "choose-plugin", "P", false, "Choose the plugin to use")
 	flags.StringVarP(&appCfg.Model, "model", "m", "", "The name of the model to use")
 	flags.BoolVarP(&appCfg.ChooseAIModel, "choose-model", "M", false, "Choose the model to use")
@@ -91,9 +110,26 @@ func initializeFlags(app *App) {
 	flags.BoolVarP(&appCfg.Version, "version", "v", false, "Print the version")
 	flags.StringVarP(&appCfg.WorkflowPath, "workflow", "w", "", "The path to a workflow file")
 	flags.BoolVarP(&appCfg.ChooseWorkflow, "choose-workflow", "W", false, "Choose a workflow to run")
+	flags.StringArrayVarP(&appCfg.Inputs, "set", "", nil, "Set a workflow input, e.g. --set tone=formal")
+	flags.StringVarP(&appCfg.InputFile, "input-file", "", "", "A JSON file of workflow inputs")
 	flags.BoolVarP(&appCfg.IteratorPrompt, "iterator", "i", false, "String array of prompts ['prompt1', 'prompt2']")
 	flags.BoolVarP(&appCfg.Feedback, "feedback", "f", false, "Optionally provide feedback and rerun workflow")
+	flags.IntVarP(&appCfg.Parallel, "parallel", "", 0, "Number of workflow iterations to run at once")
+	flags.StringVarP(&appCfg.Session, "session", "s", "", "Continue the named conversation session")
+	flags.DurationVarP(&appCfg.Timeout, "timeout", "", 0, "Limit on each plugin call, e.g. 30s or 2m")
+	flags.StringVarP(&appCfg.Output, "output", "o", "", "Write machine-readable output, json or jsonl")
+	flags.BoolVarP(&appCfg.Usage, "usage", "", false, "Print token usage and cost after the run")
+	flags.BoolVarP(&appCfg.Cache, "cache", "", false, "Reuse cached responses for identical prompts")
+	flags.DurationVarP(&appCfg.CacheTTL, "cache-ttl", "", defaultCacheTTL, "How long cached responses are used")
 	flags.SortFlags = false
+
+	persistentFlags := app.RootCmd.PersistentFlags()
+	persistentFlags.StringVarP(&appCfg.ConfigPath, "config", "", "", "The path to the config file")
+	persistentFlags.StringVarP(&appCfg.Profile, "profile", "", "", "The config profile to use")
+
+	app.RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return configError(err)
+	})
 }
 
 // Generates a prompt for the chat completions
@@ -137,8 +173,8 @@ func generatePrompt(args []string, ask bool) string {
 	return prompt
 }
 
-// Overrides the plugin config with the user flags
-func overridePluginConfigWithUserFlags(appConfig AppConfig, pluginConfig CompletionPluginConfig) CompletionPluginConfig {
+// Overrides the plugin config with the user flags, environment variables, and configured defaults
+func overridePluginConfigWithUserFlags(appConfig AppConfig, pluginConfig assembllm.CompletionPluginConfig) assembllm.CompletionPluginConfig {
 	if appConfig.Model != "" {
 		pluginConfig.Model = appConfig.Model
 	}
@@ -155,13 +191,13 @@ func overridePluginConfigWithUserFlags(appConfig AppConfig, pluginConfig Complet
 }
 
 func choosePlugin() (string, error) {
-	pluginCfgs, err := getAvailablePlugins(getConfigPath())
+	cfg, err := loadConfig()
 	if err != nil {
 		return "", err
 	}
 
 	var opts []huh.Option[string]
-	for _, plugin := range pluginCfgs.Plugins {
+	for _, plugin := range cfg.Plugins.Plugins {
 		opts = append(opts, huh.Option[string]{
 			Key:   plugin.Name,
 			Value: plugin.Name,
@@ -179,12 +215,34 @@ func choosePlugin() (string, error) {
 	return plugin, nil
 }
 
-func createSpinner(action func()) error {
-	return spinner.New().
+// Runs the action behind a spinner, waiting for it to finish even when the spinner can't be shown
+// Quitting the spinner with ctrl+c cancels the action's context and waits for it to return
+func createSpinner(cancel context.CancelFunc, action func()) error {
+	done := make(chan struct{})
+	err := spinner.New().
 		Title("Generating...").
 		TitleStyle(lipgloss.NewStyle().Faint(true)).
-		Action(action).
+		Action(func() {
+			defer close(done)
+			action()
+		}).
 		Run()
+
+	select {
+	case <-done:
+		return nil
+	default:
+	}
+
+	// The spinner couldn't be shown, so let the action finish without it
+	if err != nil {
+		<-done
+		return nil
+	}
+
+	cancel()
+	<-done
+	return errInterrupted
 }
 
 func printVersion() {
@@ -225,24 +283,31 @@ func buildIteratorPrompts(args []string) []string {
 	return prompts
 }
 
-func executeCompletion(pc CompletionPluginConfig, prompt string, spin bool) (string, error) {
-	var res string
-	var err error
-
-	if spin {
-		err = createSpinner(
-			func() {
-				res, err = pc.generateResponse(prompt, appCfg.Raw)
-			},
-		)
-	} else {
-		res, err = pc.generateResponse(prompt, appCfg.Raw)
+// Formats the response as markdown unless raw output is requested
+func formatResponse(response string, raw bool) string {
+	if raw {
+		return response
 	}
+
+	formattedResponse, _ := glamour.Render(response, "dark")
+	return formattedResponse
+}
+
+// Gets the completions response for the prompt, returning what remains to be printed
+// Responses from streaming plugins are printed as they arrive
+func executeCompletion(ctx context.Context, pc assembllm.CompletionPluginConfig, prompt string, spin bool) (string, error) {
+	res, streamed, err := generateWithStreaming(ctx, pc, spin, func(ctx context.Context, pc assembllm.CompletionPluginConfig) (string, error) {
+		return pc.GenerateResponseContext(ctx, prompt)
+	})
 	if err != nil {
 		return "", err
 	}
 
-	return res, nil
+	if streamed {
+		return "", nil
+	}
+
+	return formatResponse(res, appCfg.Raw), nil
 }
 
 func runCommand(cmd *cobra.Command, args []string) error {
@@ -251,6 +316,10 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+
 	if appCfg.ChooseWorkflow {
 		wp, err := chooseWorkflow()
 
@@ -261,7 +330,7 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	if appCfg.WorkflowPath != "" {
-		return executeWorkflow(args)
+		return executeWorkflow(cmd.Context(), args)
 	}
 
 	if appCfg.ChoosePlugin {
@@ -272,9 +341,14 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		appCfg.Name = pluginName
 	}
 
-	pluginCfg, err := getPluginConfig(appCfg.Name, getConfigPath())
+	client, err := newClient()
 	if err != nil {
-		return err
+		return configError(err)
+	}
+
+	pluginCfg, err := client.Plugin(appCfg.Name)
+	if err != nil {
+		return configError(err)
 	}
 
 	pluginCfg = overridePluginConfigWithUserFlags(appCfg, pluginCfg)
@@ -286,13 +360,37 @@ func runCommand(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	complete := func(prompt string) (string, error) {
+		return executeCompletion(cmd.Context(), pluginCfg, prompt, true)
+	}
+
+	if appCfg.Session != "" {
+		s, err := loadSession(appCfg.Session)
+		if err != nil {
+			return err
+		}
+		complete = func(prompt string) (string, error) {
+			return executeSessionCompletion(cmd.Context(), s, pluginCfg, prompt, true)
+		}
+	}
+
+	if appCfg.Output != "" {
+		var prompts []string
+		if appCfg.IteratorPrompt {
+			prompts = buildIteratorPrompts(args)
+		} else {
+			prompts = []string{generatePrompt(args, true)}
+		}
+		return writeCompletionRecords(pluginCfg, prompts, complete)
+	}
+
 	if appCfg.IteratorPrompt {
 		prompts := buildIteratorPrompts(args)
 
 		for _, p := range prompts {
-			res, err := executeCompletion(pluginCfg, p, true)
+			res, err := complete(p)
 			if err != nil {
-				return err
+				return runError(err)
 			}
 
 			fmt.Println(res)
@@ -301,9 +399,9 @@ func runCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	prompt := generatePrompt(args, true)
-	res, err := executeCompletion(pluginCfg, prompt, true)
+	res, err := complete(prompt)
 	if err != nil {
-		return err
+		return runError(err)
 	}
 
 	fmt.Print(res)
@@ -320,14 +418,35 @@ func main() {
 			RunE:          runCommand,
 			SilenceUsage:  true,
 			SilenceErrors: true,
+			PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+				if err := setupConfig(); err != nil {
+					return err
+				}
+				return applyDefaults(cmd)
+			},
 		},
 	}
 
 	initializeFlags(app)
-	setupConfig()
+	app.RootCmd.AddCommand(newChatCommand(), newSessionsCommand(), newWorkflowCommand(), newLedgerCommand(), newCacheCommand(), newPluginCommand(), newConfigCommand(), newServeCommand(), newMCPCommand())
 
-	if err := app.RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// Cancel in-flight plugin calls and workflows on ctrl+c rather than exiting mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := app.RootCmd.ExecuteContext(ctx)
+	finishUsage()
+
+	if err != nil {
+		kind, code := classifyError(ctx, err)
+		switch {
+		case appCfg.Output != "":
+			writeErrorRecord(kind, code, err)
+		case kind == "interrupted":
+			fmt.Println(errInterrupted)
+		default:
+			fmt.Println(err)
+		}
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+// The Model Context Protocol version the server speaks
+const mcpProtocolVersion = "2024-11-05"
+
+// The longest tool name many clients accept
+const mcpToolNameLimit = 64
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// A JSON-RPC request, notification, or response, sent one per line over stdio
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	InputSchema assembllm.Schema `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError"`
+}
+
+// Serves the workflows in a directory as Model Context Protocol tools
+type mcpServer struct {
+	client      *assembllm.Client
+	workflowDir string
+
+	out   io.Writer
+	outMu sync.Mutex
+
+	// Cancels in-flight tool calls, keyed by request id
+	calls   map[string]context.CancelFunc
+	callsMu sync.Mutex
+	wg      sync.WaitGroup
+}
+
+func newMCPCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "mcp",
+		Short:         "Serve workflows as tools to MCP clients over stdio",
+		Args:          cobra.NoArgs,
+		RunE:          runMCP,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := cmd.Flags()
+	flags.String("workflows", "", "The directory of workflows to serve as tools (default ~/.assembllm/workflows)")
+	flags.DurationVarP(&appCfg.Timeout, "timeout", "", 0, "Limit on each plugin call, e.g. 30s or 2m")
+	flags.BoolVarP(&appCfg.Cache, "cache", "", false, "Reuse cached responses for identical prompts")
+	flags.DurationVarP(&appCfg.CacheTTL, "cache-ttl", "", defaultCacheTTL, "How long cached responses are used")
+	flags.SortFlags = false
+
+	return cmd
+}
+
+func runMCP(cmd *cobra.Command, args []string) error {
+	workflowDir, _ := cmd.Flags().GetString("workflows")
+	if workflowDir == "" {
+		workflowDir = filepath.Join(getAppDir(), "workflows")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	// Usage is totalled and recorded for each tool call instead
+	client.OnUsage = nil
+
+	s := &mcpServer{client: client, workflowDir: expandHome(workflowDir)}
+	return s.serve(cmd.Context(), os.Stdin, os.Stdout)
+}
+
+// Handles messages until the input ends, waiting for in-flight tool calls to finish
+func (s *mcpServer) serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.out = out
+	s.calls = map[string]context.CancelFunc{}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxRequestBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg rpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			s.send(rpcMessage{Error: &rpcError{Code: rpcParseError, Message: err.Error()}, ID: json.RawMessage("null")})
+			continue
+		}
+		s.dispatch(ctx, msg)
+	}
+
+	s.wg.Wait()
+	return scanner.Err()
+}
+
+func (s *mcpServer) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding message: %v\n", err)
+		return
+	}
+
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Fprintf(s.out, "%s\n", data)
+}
+
+func (s *mcpServer) reply(id json.RawMessage, result interface{}, err *rpcError) {
+	s.send(rpcMessage{ID: id, Result: result, Error: err})
+}
+
+func (s *mcpServer) dispatch(ctx context.Context, msg rpcMessage) {
+	// Notifications have no id and get no response, and the server sends no requests of its own to get responses for
+	if msg.Method == "" {
+		return
+	}
+	if msg.ID == nil {
+		if msg.Method == "notifications/cancelled" {
+			var params struct {
+				RequestID json.RawMessage `json:"requestId"`
+			}
+			if json.Unmarshal(msg.Params, &params) == nil {
+				s.cancel(params.RequestID)
+			}
+		}
+		return
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg.ID, s.initialize(), nil)
+	case "ping":
+		s.reply(msg.ID, struct{}{}, nil)
+	case "tools/list":
+		tools, err := s.tools()
+		if err != nil {
+			s.reply(msg.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+			return
+		}
+		s.reply(msg.ID, map[string]interface{}{"tools": tools}, nil)
+	case "tools/call":
+		// Calls run concurrently, so later messages such as cancellations are still read
+		callCtx, cancel := context.WithCancel(ctx)
+		s.callsMu.Lock()
+		s.calls[string(msg.ID)] = cancel
+		s.callsMu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.cancel(msg.ID)
+
+			result, err := s.callTool(callCtx, msg.Params)
+			if callCtx.Err() != nil && ctx.Err() == nil {
+				// Cancelled by the client, which expects no response
+				return
+			}
+			s.reply(msg.ID, result, err)
+		}()
+	default:
+		s.reply(msg.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + msg.Method})
+	}
+}
+
+func (s *mcpServer) cancel(id json.RawMessage) {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+
+	if cancel, ok := s.calls[string(id)]; ok {
+		cancel()
+		delete(s.calls, string(id))
+	}
+}
+
+func (s *mcpServer) initialize() interface{} {
+	return map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+		"serverInfo":      map[string]string{"name": "assembllm", "version": version},
+	}
+}
+
+// Gets a tool for each workflow in the directory, skipping those that can't be parsed
+func (s *mcpServer) tools() ([]mcpTool, error) {
+	names, err := listWorkflows(s.workflowDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list workflows: %v", err)
+	}
+
+	tools := []mcpTool{}
+	seen := map[string]string{}
+	for _, name := range names {
+		toolName := mcpToolName(name)
+		if other, ok := seen[toolName]; ok {
+			fmt.Fprintf(os.Stderr, "%s: skipped: tool name %s is already used by %s\n", name, toolName, other)
+			continue
+		}
+		seen[toolName] = name
+
+		path, _ := findWorkflow(s.workflowDir, name)
+		workflow, err := s.client.LoadWorkflowFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: skipped: %v\n", path, err)
+			continue
+		}
+		tools = append(tools, workflowTool(name, workflow))
+	}
+
+	return tools, nil
+}
+
+// Gets the tool name for a workflow, tool names are limited to letters, digits, _ and - and to 64 characters
+// by many clients, so other characters in the workflow name are replaced with _ and long names are cut
+func mcpToolName(workflow string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, workflow)
+	if len(name) > mcpToolNameLimit {
+		name = name[:mcpToolNameLimit]
+	}
+	return name
+}
+
+// Gets the workflow listed under a tool name, the first of any workflows sharing it as in tools
+func (s *mcpServer) findTool(toolName string) (string, string, bool) {
+	names, err := listWorkflows(s.workflowDir)
+	if err != nil {
+		return "", "", false
+	}
+	for _, name := range names {
+		if mcpToolName(name) == toolName {
+			path, ok := findWorkflow(s.workflowDir, name)
+			return name, path, ok
+		}
+	}
+	return "", "", false
+}
+
+// Describes a workflow as a tool taking the workflow's input and its declared inputs
+func workflowTool(name string, workflow *assembllm.Workflow) mcpTool {
+	description := strings.TrimSpace(workflow.Tasks.Description)
+	if description == "" {
+		description = "Runs the " + name + " workflow"
+	}
+
+	schema := assembllm.Schema{
+		Type: "object",
+		Properties: map[string]assembllm.Property{
+			"input": {Type: "string", Description: "The input to the workflow"},
+		},
+		Required: []string{},
+	}
+	for _, in := range workflow.Tasks.Inputs {
+		schema.Properties[in.Name] = assembllm.Property{Type: in.Kind(), Description: in.Description}
+		if in.Required && in.Default == nil {
+			schema.Required = append(schema.Required, in.Name)
+		}
+	}
+
+	return mcpTool{Name: mcpToolName(name), Description: description, InputSchema: schema}
+}
+
+// Runs the workflow named by the call, returning its output, or its error as a tool error the model can see
+// Arguments other than input are the workflow's inputs
+func (s *mcpServer) callTool(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	input, _ := p.Arguments["input"].(string)
+	inputs := map[string]interface{}{}
+	for k, v := range p.Arguments {
+		if k != "input" {
+			inputs[k] = v
+		}
+	}
+
+	name, path, ok := s.findTool(p.Name)
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + p.Name}
+	}
+
+	record, err := runWorkflowFile(ctx, *s.client, name, path, input, inputs)
+	if err != nil {
+		var ce *cliError
+		if !errors.As(err, &ce) || ce.kind != "run" {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		}
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+
+	var out strings.Builder
+	for _, iteration := range record.Iterations {
+		out.WriteString(iteration.Response)
+	}
+
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: out.String()}}}, nil
+}
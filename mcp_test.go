@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestMCPServer(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	workflow := "description: Says hello\ntasks:\n  - name: greet\n    post_script: '\"hello\"'\n"
+	if err := os.WriteFile(filepath.Join(dir, "greet.yaml"), []byte(workflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Names that aren't valid tool names are listed and called under a sanitized name
+	for _, name := range []string{"my.flow", "my_flow", strings.Repeat("a", 70)} {
+		if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(workflow), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tone := "inputs:\n  - name: tone\n    required: true\ntasks:\n  - name: tone\n    post_script: inputs.tone\n"
+	if err := os.WriteFile(filepath.Join(dir, "tone.yaml"), []byte(tone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2024-11-05"}}`,
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/list"}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "greet", "arguments": {"input": "world"}}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "tools/call", "params": {"name": "missing"}}`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "resources/list"}`,
+		`{"jsonrpc": "2.0", "id": 6, "method": "tools/call", "params": {"name": "tone", "arguments": {"tone": "formal"}}}`,
+		`{"jsonrpc": "2.0", "id": 7, "method": "tools/call", "params": {"name": "my_flow"}}`,
+		`{"jsonrpc": "2.0", "id": 8, "method": "tools/call", "params": {"name": "my.flow"}}`,
+	}, "\n")
+
+	s := &mcpServer{client: assembllm.NewClient(assembllm.CompletionPluginConfigs{}), workflowDir: dir}
+	var out bytes.Buffer
+	if err := s.serve(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	responses := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		responses[string(msg.ID)] = line
+	}
+
+	want := map[string]string{
+		"1": `"protocolVersion":"2024-11-05"`,
+		"2": `"name":"greet","description":"Says hello"`,
+		"3": `"content":[{"type":"text","text":"hello"}],"isError":false`,
+		"4": `"unknown tool: missing"`,
+		"5": `"code":-32601`,
+		"6": `"text":"formal"`,
+		"7": `"content":[{"type":"text","text":"hello"}],"isError":false`,
+		"8": `"unknown tool: my.flow"`,
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("want %d responses, got %d: %s", len(want), len(responses), out.String())
+	}
+	for id, w := range want {
+		if !strings.Contains(responses[id], w) {
+			t.Fatalf("want %s in response %s, got %s", w, id, responses[id])
+		}
+	}
+
+	var list struct {
+		Result struct {
+			Tools []mcpTool `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(responses["2"]), &list); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	var names []string
+	for _, tool := range list.Result.Tools {
+		names = append(names, tool.Name)
+	}
+	// my_flow.yaml is skipped as my.flow.yaml already has its tool name
+	wantNames := []string{strings.Repeat("a", 64), "greet", "my_flow", "tone"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("want tools %v, got %v", wantNames, names)
+	}
+	if !strings.Contains(responses["2"], `"tone":{"type":"string","description":""}},"required":["tone"]`) {
+		t.Fatalf("want the tone input in the tool schema, got %s", responses["2"])
+	}
+}
+
+func TestMCPToolName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		workflow string
+		want     string
+	}{
+		{"greet", "greet"},
+		{"summarize-v2_final", "summarize-v2_final"},
+		{"my.flow", "my_flow"},
+		{"v1.2.3", "v1_2_3"},
+		{strings.Repeat("a", 64), strings.Repeat("a", 64)},
+		{strings.Repeat("a", 65), strings.Repeat("a", 64)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.workflow, func(t *testing.T) {
+			if got := mcpToolName(tt.workflow); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
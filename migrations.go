@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The version of the configuration file written by this release, stored as version in config.yaml
+const configVersion = 1
+
+// Updates the configuration file from the previous version
+type configMigration struct {
+	version int
+	apply   func(doc *configDocument)
+}
+
+// Migrations in the order they apply, each bumping the configuration to its version
+var configMigrations = []configMigration{
+	{version: 1, apply: migrateModsurferSources},
+}
+
+// Applies the migrations newer than the configuration's version, returning whether anything changed
+// Configurations written by a newer release are left as they are
+func migrateConfig(data []byte) ([]byte, int, bool, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, 0, false, fmt.Errorf("failed to get config from yaml: %v", err)
+	}
+	if len(root.Content) == 0 {
+		return data, 0, false, nil
+	}
+
+	doc, err := newConfigDocument(root)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	version, err := doc.version()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if version >= configVersion {
+		return data, version, false, nil
+	}
+
+	for _, m := range configMigrations {
+		if m.version > version {
+			m.apply(doc)
+		}
+	}
+	doc.setVersion(configVersion)
+
+	out, err := doc.encode()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return out, version, true, nil
+}
+
+// Points plugins at the GitHub releases that replaced their modsurfer modules
+// Only the source and hash of matching plugins change, values elsewhere in the file are kept
+func migrateModsurferSources(doc *configDocument) {
+	for _, node := range doc.plugins.Content {
+		source := scalarValue(node, "source")
+		for _, update := range modsurferUpdates {
+			for _, old := range update.Old {
+				if source != old.Source {
+					continue
+				}
+				setScalar(node, "source", update.New.Source)
+				setMappingValue(node, "hash", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+			}
+		}
+	}
+}
+
+// Mapping of old plug-in sources and hashes to latest
+var modsurferUpdates = []struct {
+	Old []struct {
+		Source, Hash string
+	}
+	New struct {
+		Source, Hash string
+	}
+}{
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7.wasm",
+				Hash:   "114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7",
+			},
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/e5768c2835a01ee1a5f10702020a82e0ba2166ba114733e2215b2c2ef423985f.wasm",
+				Hash:   "e5768c2835a01ee1a5f10702020a82e0ba2166ba114733e2215b2c2ef423985f",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-openai/releases/latest/download/assembllm_openai.wasm",
+			Hash:   "",
+		},
+	},
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/dd58ff133011b296ff5ba00cc3b0b4df34c1a176e5aebff9643d1ac83b88c72b.wasm",
+				Hash:   "dd58ff133011b296ff5ba00cc3b0b4df34c1a176e5aebff9643d1ac83b88c72b",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-cloudflare/releases/latest/download/assembllm_cloudflare.wasm",
+			Hash:   "",
+		},
+	},
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/9c1a87483040d5033866fc5b8581cc8aa7bc18abd9a601a14a4dec998a5a75f9.wasm",
+				Hash:   "9c1a87483040d5033866fc5b8581cc8aa7bc18abd9a601a14a4dec998a5a75f9",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-perplexity/releases/latest/download/assembllm_perplexity.wasm",
+			Hash:   "",
+		},
+	},
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/93f3517589bd44dfde3a0406ab2d574f239aca10378996bb6c63e8d73a510e2b.wasm",
+				Hash:   "93f3517589bd44dfde3a0406ab2d574f239aca10378996bb6c63e8d73a510e2b",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-openai-go/releases/latest/download/assembllm-openai-go.wasm",
+			Hash:   "",
+		},
+	},
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/6d2e458bf3eea4925503bc7803c0d01366430a8e2779bd088b8f9887745b4e00.wasm",
+				Hash:   "6d2e458bf3eea4925503bc7803c0d01366430a8e2779bd088b8f9887745b4e00",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-openai-csharp/releases/latest/download/assembllm-openai-csharp.wasm",
+			Hash:   "",
+		},
+	},
+	{
+		Old: []struct{ Source, Hash string }{
+			{
+				Source: "https://cdn.modsurfer.dylibso.com/api/v1/module/a9110e703ff5c68cbf028c725851fd287ac1ef0b909b1d97c600f881e272fa8c.wasm",
+				Hash:   "a9110e703ff5c68cbf028c725851fd287ac1ef0b909b1d97c600f881e272fa8c",
+			},
+		},
+		New: struct{ Source, Hash string }{
+			Source: "https://github.com/bradyjoslin/assembllm-openai-ts/releases/latest/download/assembllm-openai-ts.wasm",
+			Hash:   "",
+		},
+	},
+}
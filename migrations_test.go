@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	t.Parallel()
+
+	legacy := `# my plugins
+completion-plugins:
+  - name: openai
+    source: https://cdn.modsurfer.dylibso.com/api/v1/module/114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7.wasm
+    hash: 114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7
+    apiKey: OPENAI_API_KEY # read from the environment
+    url: api.openai.com
+  - name: local
+    source: ~/plugins/114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7.wasm
+    url: localhost
+`
+
+	migrated, version, changed, err := migrateConfig([]byte(legacy))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !changed || version != 0 {
+		t.Fatalf("want a change from version 0, got %v from %d", changed, version)
+	}
+
+	got := string(migrated)
+	wants := []string{
+		"version: 1\n",
+		"# my plugins",
+		"# read from the environment",
+		"source: https://github.com/bradyjoslin/assembllm-openai/releases/latest/download/assembllm_openai.wasm",
+		"source: ~/plugins/114e1e892c43baefb4d50cc8b0e9f66df2b2e3177de9293ffdd83898c77e04c7.wasm",
+	}
+	for _, want := range wants {
+		if !strings.Contains(got, want) {
+			t.Fatalf("want %q in migrated config, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "hash: 114e1e") {
+		t.Fatalf("expected the old hash to be removed, got:\n%s", got)
+	}
+
+	_, version, changed, err = migrateConfig(migrated)
+	if err != nil || changed || version != configVersion {
+		t.Fatalf("want no change at version %d, got %v at %d, %v", configVersion, changed, version, err)
+	}
+}
+
+func TestMigrateDefaultConfig(t *testing.T) {
+	t.Parallel()
+
+	_, _, changed, err := migrateConfig(defaultConfig)
+	if err != nil || changed {
+		t.Fatalf("expected the default config to be current, got %v, %v", changed, err)
+	}
+}
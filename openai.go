@@ -0,0 +1,486 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+// Requests and responses of the OpenAI chat completions API, translated to and from plugin calls
+// Models are named plugin/model, e.g. anthropic/claude-3-5-sonnet-20240620
+
+type openAIChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature *float64        `json:"temperature"`
+	Tools       []openAITool    `json:"tools"`
+	Stream      bool            `json:"stream"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role,omitempty"`
+	Content    openAIContent    `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// Message content, sent as a string or as an array of parts of which only text is supported
+type openAIContent string
+
+func (c *openAIContent) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != nil {
+			*c = openAIContent(*s)
+		}
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of parts")
+	}
+
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.Type != "text" {
+			return fmt.Errorf("unsupported content part: %s", part.Type)
+		}
+		sb.WriteString(part.Text)
+	}
+	*c = openAIContent(sb.String())
+	return nil
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+type openAIToolCall struct {
+	// Position of the call in a streamed response
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *openAIMessage `json:"message,omitempty"`
+	Delta        *openAIMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+// Gets the plugin for an OpenAI model name, either plugin/model, a plugin name, or a model of the default plugin
+// A plugin/ prefix that isn't a configured plugin is not found, rather than sent to the default plugin
+func (s *apiServer) openAIPlugin(model string) (assembllm.CompletionPluginConfig, error) {
+	if name, pluginModel, ok := strings.Cut(model, "/"); ok {
+		pluginCfg, err := s.plugin(name)
+		if err == nil && pluginModel != "" {
+			pluginCfg.Model = pluginModel
+		}
+		return pluginCfg, err
+	}
+
+	if _, err := s.client.Plugins.GetPlugin(model); err == nil {
+		return s.plugin(model)
+	}
+
+	pluginCfg, err := s.plugin("")
+	if model != "" {
+		pluginCfg.Model = model
+	}
+	return pluginCfg, err
+}
+
+// Converts OpenAI messages to plugin messages, returning the system messages separately as the role
+// Assistant tool calls and tool results are sent the way the workflow tool loop sends them
+func convertOpenAIMessages(messages []openAIMessage) ([]assembllm.Message, string, error) {
+	var converted []assembllm.Message
+	var system []string
+	var results []assembllm.ToolResult
+	toolNames := map[string]string{}
+
+	flushResults := func() error {
+		if len(results) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		converted = append(converted, assembllm.Message{Role: "user", Content: "Tool results: " + string(data)})
+		results = nil
+		return nil
+	}
+
+	for _, m := range messages {
+		if m.Role != "tool" {
+			if err := flushResults(); err != nil {
+				return nil, "", err
+			}
+		}
+
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, string(m.Content))
+		case "user":
+			converted = append(converted, assembllm.Message{Role: "user", Content: string(m.Content)})
+		case "assistant":
+			if len(m.ToolCalls) == 0 {
+				converted = append(converted, assembllm.Message{Role: "assistant", Content: string(m.Content)})
+				continue
+			}
+
+			var calls []assembllm.ToolCall
+			for _, tc := range m.ToolCalls {
+				var input map[string]interface{}
+				if tc.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(tc.Function.Arguments), &input); err != nil {
+						return nil, "", fmt.Errorf("invalid arguments for tool call %s: %v", tc.ID, err)
+					}
+				}
+				calls = append(calls, assembllm.ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: input})
+				toolNames[tc.ID] = tc.Function.Name
+			}
+			data, err := json.Marshal(calls)
+			if err != nil {
+				return nil, "", err
+			}
+			converted = append(converted, assembllm.Message{Role: "assistant", Content: string(data)})
+		case "tool":
+			results = append(results, assembllm.ToolResult{ID: m.ToolCallID, Name: toolNames[m.ToolCallID], Output: string(m.Content)})
+		default:
+			return nil, "", fmt.Errorf("unsupported message role: %s", m.Role)
+		}
+	}
+
+	if err := flushResults(); err != nil {
+		return nil, "", err
+	}
+
+	return converted, strings.Join(system, "\n\n"), nil
+}
+
+func convertOpenAITools(tools []openAITool) ([]assembllm.Tool, error) {
+	var converted []assembllm.Tool
+	for _, t := range tools {
+		if t.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type: %s", t.Type)
+		}
+
+		tool := assembllm.Tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: assembllm.Schema{Type: "object", Properties: map[string]assembllm.Property{}},
+		}
+		if len(t.Function.Parameters) > 0 {
+			if err := json.Unmarshal(t.Function.Parameters, &tool.InputSchema); err != nil {
+				return nil, fmt.Errorf("invalid parameters for tool %s: %v", t.Function.Name, err)
+			}
+		}
+		converted = append(converted, tool)
+	}
+
+	return converted, nil
+}
+
+// Gets the response message for a plugin response, with the tool calls it requests when tools were offered
+func openAIResponseMessage(res string, tools []assembllm.Tool) (openAIMessage, string) {
+	calls, ok := assembllm.ParseToolCalls(res)
+	if len(tools) == 0 || !ok {
+		return openAIMessage{Role: "assistant", Content: openAIContent(res)}, "stop"
+	}
+
+	msg := openAIMessage{Role: "assistant"}
+	for i, call := range calls {
+		tc := openAIToolCall{ID: call.ID, Type: "function"}
+		if tc.ID == "" {
+			tc.ID = fmt.Sprintf("call_%d", i)
+		}
+		tc.Function.Name = call.Name
+
+		args, err := json.Marshal(call.Input)
+		if err != nil || call.Input == nil {
+			args = []byte("{}")
+		}
+		tc.Function.Arguments = string(args)
+		msg.ToolCalls = append(msg.ToolCalls, tc)
+	}
+
+	return msg, "tool_calls"
+}
+
+// Content is always sent in responses, as null when a message only has tool calls
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	type message openAIMessage
+	var content *string
+	if m.Content != "" || len(m.ToolCalls) == 0 {
+		s := string(m.Content)
+		content = &s
+	}
+
+	return json.Marshal(struct {
+		message
+		Content *string `json:"content"`
+	}{message(m), content})
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+func (s *apiServer) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+
+	err := s.openAIChat(w, r)
+	status := http.StatusOK
+	if err != nil {
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			ae = &apiError{status: http.StatusInternalServerError, kind: "error", err: err}
+		}
+		status = ae.status
+
+		var record apiErrorRecord
+		record.Error.Kind = ae.kind
+		record.Error.Message = ae.Error()
+		writeJSON(w, status, record)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s %s %d %s\n", r.Method, r.URL.Path, status, time.Since(start).Round(time.Millisecond))
+}
+
+// Answers a chat completions request, streaming it as server-sent events when requested
+// Errors are returned before anything is written, so they can still be sent as json
+func (s *apiServer) openAIChat(w http.ResponseWriter, r *http.Request) error {
+	var req openAIChatRequest
+	// Unknown fields such as max_tokens are ignored, since OpenAI clients send many the plugins don't take
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest(fmt.Errorf("invalid request body: %v", err))
+	}
+	if len(req.Messages) == 0 {
+		return badRequest(errors.New("messages is required"))
+	}
+
+	messages, system, err := convertOpenAIMessages(req.Messages)
+	if err != nil {
+		return badRequest(err)
+	}
+	tools, err := convertOpenAITools(req.Tools)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	pluginCfg, err := s.openAIPlugin(req.Model)
+	if err != nil {
+		return err
+	}
+	flags := AppConfig{Role: system}
+	if req.Temperature != nil {
+		flags.Temperature = strconv.FormatFloat(*req.Temperature, 'f', -1, 64)
+	}
+	pluginCfg = overridePluginConfigWithUserFlags(flags, pluginCfg)
+
+	usage := &usageTotals{}
+	pluginCfg.OnUsage = usage.add
+	defer recordUsage(usage, "")
+
+	response := openAIChatResponse{
+		ID:      newCompletionID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   pluginCfg.Name + "/" + pluginCfg.Model,
+	}
+	if pluginCfg.Model == "" {
+		response.Model = pluginCfg.Name
+	}
+
+	var stream *openAIStream
+	if req.Stream {
+		stream = &openAIStream{w: w, response: response}
+		// Tool calls are only known once the response is complete, so only answers are streamed
+		if len(tools) == 0 {
+			pluginCfg.OnChunk = stream.content
+		}
+	}
+
+	var res string
+	if len(tools) > 0 {
+		res, err = pluginCfg.GenerateResponseWithMessagesContext(r.Context(), messages, tools)
+	} else {
+		res, err = pluginCfg.GenerateChatResponseContext(r.Context(), messages)
+	}
+	if err != nil {
+		if stream != nil && stream.started {
+			stream.fail(err)
+			return nil
+		}
+		return runFailure(err)
+	}
+
+	msg, finish := openAIResponseMessage(res, tools)
+	if total := usage.reported(); total != nil {
+		response.Usage = &openAIUsage{
+			PromptTokens:     total.InputTokens,
+			CompletionTokens: total.OutputTokens,
+			TotalTokens:      total.InputTokens + total.OutputTokens,
+		}
+	}
+
+	if stream != nil {
+		stream.finish(msg, finish)
+		return nil
+	}
+
+	response.Choices = []openAIChoice{{Message: &msg, FinishReason: &finish}}
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+// Writes a chat completion as server-sent events
+type openAIStream struct {
+	w        http.ResponseWriter
+	response openAIChatResponse
+	started  bool
+}
+
+func (s *openAIStream) send(v interface{}) {
+	if !s.started {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *openAIStream) chunk(delta openAIMessage, finish *string) openAIChatResponse {
+	chunk := s.response
+	chunk.Object = "chat.completion.chunk"
+	chunk.Usage = nil
+	chunk.Choices = []openAIChoice{{Delta: &delta, FinishReason: finish}}
+	return chunk
+}
+
+// Sends a chunk of the answer as the plugin streams it
+func (s *openAIStream) content(text string) {
+	delta := openAIMessage{Content: openAIContent(text)}
+	if !s.started {
+		delta.Role = "assistant"
+	}
+	s.send(s.chunk(delta, nil))
+}
+
+// Sends whatever wasn't streamed, then the finish reason and usage
+func (s *openAIStream) finish(msg openAIMessage, finish string) {
+	if !s.started {
+		for i := range msg.ToolCalls {
+			index := i
+			msg.ToolCalls[i].Index = &index
+		}
+		s.send(s.chunk(msg, nil))
+	}
+
+	last := s.chunk(openAIMessage{}, &finish)
+	last.Usage = s.response.Usage
+	s.send(last)
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+}
+
+// Reports an error after the response has started, as an event clients can show
+func (s *openAIStream) fail(err error) {
+	var record apiErrorRecord
+	record.Error.Kind = "run"
+	record.Error.Message = err.Error()
+	s.send(record)
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+}
+
+// Lists the models of every plugin as plugin/model, skipping plugins whose models can't be listed
+func (s *apiServer) listOpenAIModels(r *http.Request) (interface{}, error) {
+	plugins := s.client.Plugins.Plugins
+	models := make([][]string, len(plugins))
+
+	var wg sync.WaitGroup
+	for i, p := range plugins {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			pluginCfg, err := s.plugin(name)
+			if err == nil {
+				models[i], err = pluginCfg.GetModels()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: unable to list models: %v\n", name, err)
+			}
+		}(i, p.Name)
+	}
+	wg.Wait()
+
+	list := openAIModelList{Object: "list", Data: []openAIModel{}}
+	for i, p := range plugins {
+		for _, model := range models[i] {
+			list.Data = append(list.Data, openAIModel{ID: p.Name + "/" + model, Object: "model", OwnedBy: p.Name})
+		}
+	}
+
+	return list, nil
+}
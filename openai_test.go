@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestConvertOpenAIMessages(t *testing.T) {
+	t.Parallel()
+
+	var req openAIChatRequest
+	err := json.Unmarshal([]byte(`{"messages": [
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": [{"type": "text", "text": "weather in "}, {"type": "text", "text": "Austin?"}]},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{\"location\":\"Austin\"}"}}]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+	]}`), &req)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	messages, system, err := convertOpenAIMessages(req.Messages)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if system != "be brief" {
+		t.Fatalf("want be brief, got %s", system)
+	}
+	if len(messages) != 3 || messages[0].Content != "weather in Austin?" {
+		t.Fatalf("want 3 messages starting with the user prompt, got %+v", messages)
+	}
+	if messages[1].Content != `[{"id":"call_1","name":"weather","input":{"location":"Austin"}}]` {
+		t.Fatalf("want the tool call as json, got %s", messages[1].Content)
+	}
+	if want := `Tool results: [{"id":"call_1","name":"weather","output":"sunny"}]`; messages[2].Role != "user" || messages[2].Content != want {
+		t.Fatalf("want %s, got %s", want, messages[2].Content)
+	}
+}
+
+func TestOpenAIResponseMessage(t *testing.T) {
+	t.Parallel()
+
+	tools := []assembllm.Tool{{Name: "weather"}}
+
+	msg, finish := openAIResponseMessage(`[{"name": "weather", "input": {"location": "Austin"}}]`, tools)
+	if finish != "tool_calls" || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"location":"Austin"}` {
+		t.Fatalf("want a weather tool call, got %s %+v", finish, msg)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil || !strings.Contains(string(data), `"content":null`) {
+		t.Fatalf("want null content, got %s %v", data, err)
+	}
+
+	msg, finish = openAIResponseMessage("It is sunny.", tools)
+	if finish != "stop" || msg.Content != "It is sunny." {
+		t.Fatalf("want the answer, got %s %+v", finish, msg)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+// Exit codes, kept stable for scripts that check them
+const (
+	exitError       = 1
+	exitConfig      = 2
+	exitRun         = 3
+	exitTimeout     = 124
+	exitInterrupted = 130
+)
+
+// An error with the kind reported in structured output and the exit code it maps to
+type cliError struct {
+	kind string
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cliError) Unwrap() error {
+	return e.err
+}
+
+// Marks an error caused by flags, configuration, or an invalid workflow
+func configError(err error) error {
+	return &cliError{kind: "config", code: exitConfig, err: err}
+}
+
+// Marks an error from running a completion or workflow
+func runError(err error) error {
+	return &cliError{kind: "run", code: exitRun, err: err}
+}
+
+// Gets the kind of the error and the exit code for it
+func classifyError(ctx context.Context, err error) (string, int) {
+	if errors.Is(err, errInterrupted) || ctx.Err() != nil {
+		return "interrupted", exitInterrupted
+	}
+	if errors.Is(err, assembllm.ErrTimeout) {
+		return "timeout", exitTimeout
+	}
+
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.kind, ce.code
+	}
+
+	return "error", exitError
+}
+
+type completionRecord struct {
+	Type       string           `json:"type"`
+	Plugin     string           `json:"plugin"`
+	Model      string           `json:"model,omitempty"`
+	Prompt     string           `json:"prompt"`
+	Response   string           `json:"response"`
+	Usage      *assembllm.Usage `json:"usage,omitempty"`
+	DurationMs int64            `json:"duration_ms"`
+}
+
+type taskRecord struct {
+	Type       string               `json:"type"`
+	Iteration  int                  `json:"iteration"`
+	Name       string               `json:"name,omitempty"`
+	Plugin     string               `json:"plugin,omitempty"`
+	Model      string               `json:"model,omitempty"`
+	Prompt     string               `json:"prompt"`
+	Response   string               `json:"response"`
+	ToolCalls  []assembllm.ToolCall `json:"tool_calls,omitempty"`
+	Usage      *assembllm.Usage     `json:"usage,omitempty"`
+	DurationMs int64                `json:"duration_ms"`
+	Error      string               `json:"error,omitempty"`
+}
+
+type iterationRecord struct {
+	Type       string       `json:"type"`
+	Iteration  int          `json:"iteration"`
+	Value      interface{}  `json:"value,omitempty"`
+	Response   string       `json:"response"`
+	DurationMs int64        `json:"duration_ms"`
+	Error      string       `json:"error,omitempty"`
+	Tasks      []taskRecord `json:"tasks,omitempty"`
+}
+
+type workflowRecord struct {
+	Type       string                 `json:"type"`
+	Workflow   string                 `json:"workflow"`
+	Input      string                 `json:"input"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	Iterations []iterationRecord      `json:"iterations,omitempty"`
+	Usage      *assembllm.Usage       `json:"usage,omitempty"`
+	DurationMs int64                  `json:"duration_ms"`
+}
+
+type errorRecord struct {
+	Error struct {
+		Kind     string `json:"kind"`
+		Message  string `json:"message"`
+		ExitCode int    `json:"exit_code"`
+	} `json:"error"`
+}
+
+// Checks the output format flag, structured output is never rendered as markdown
+func checkOutputFormat() error {
+	switch appCfg.Output {
+	case "":
+		return nil
+	case "json", "jsonl":
+		appCfg.Raw = true
+		return nil
+	default:
+		return configError(fmt.Errorf("unknown output format: %s, use json or jsonl", appCfg.Output))
+	}
+}
+
+// Where records and error records are written, replaced in tests
+var (
+	recordWriter      io.Writer = os.Stdout
+	errorRecordWriter io.Writer = os.Stderr
+)
+
+// Writes a record to stdout, indented for json and on a single line for jsonl
+func writeRecord(v interface{}) error {
+	return encodeRecord(recordWriter, v)
+}
+
+func encodeRecord(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if appCfg.Output == "json" {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// Writes the error as a record to stderr
+func writeErrorRecord(kind string, code int, err error) {
+	var record errorRecord
+	record.Error.Kind = kind
+	record.Error.Message = err.Error()
+	record.Error.ExitCode = code
+	_ = encodeRecord(errorRecordWriter, record)
+}
+
+// Gets completions for the prompts, writing a record for each
+// With json output, several prompts are written as a single array
+func writeCompletionRecords(pc assembllm.CompletionPluginConfig, prompts []string, complete func(string) (string, error)) error {
+	var records []completionRecord
+	for _, p := range prompts {
+		start := time.Now()
+		before := runUsage.total()
+		res, err := complete(p)
+		if err != nil {
+			return runError(err)
+		}
+
+		record := completionRecord{
+			Type:       "completion",
+			Plugin:     pc.Name,
+			Model:      pc.Model,
+			Prompt:     p,
+			Response:   res,
+			Usage:      usageSince(before),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if appCfg.Output == "jsonl" {
+			if err := writeRecord(record); err != nil {
+				return err
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+
+	switch {
+	case appCfg.Output == "jsonl":
+		return nil
+	case len(records) == 1:
+		return writeRecord(records[0])
+	default:
+		return writeRecord(records)
+	}
+}
+
+// Waits for each iteration, writing records for its tasks and then the iteration as they finish with jsonl
+// output, or a single workflow record once every iteration has finished with json output
+func writeWorkflowRecords(path string, input string, inputs map[string]interface{}, values []interface{}, results []*assembllm.IterationResult, start time.Time, wrapErr func(error) error) error {
+	before := runUsage.total()
+	workflow := workflowRecord{Type: "workflow", Workflow: path, Input: input, Inputs: inputs}
+
+	for i, result := range results {
+		res, err := result.Wait()
+		iteration := newIterationRecord(i, values[i], result, res, err)
+
+		if appCfg.Output == "jsonl" {
+			for _, t := range iteration.Tasks {
+				if err := writeRecord(t); err != nil {
+					return err
+				}
+			}
+			iteration.Tasks = nil
+			if err := writeRecord(iteration); err != nil {
+				return err
+			}
+		} else {
+			workflow.Iterations = append(workflow.Iterations, iteration)
+		}
+
+		if err != nil {
+			return runError(wrapErr(err))
+		}
+	}
+
+	workflow.Usage = usageSince(before)
+	workflow.DurationMs = time.Since(start).Milliseconds()
+	return writeRecord(workflow)
+}
+
+// Gets the record for a finished iteration, including a record for each of its tasks
+func newIterationRecord(i int, value interface{}, result *assembllm.IterationResult, res string, err error) iterationRecord {
+	iteration := iterationRecord{
+		Type:       "iteration",
+		Iteration:  i,
+		Value:      value,
+		Response:   res,
+		DurationMs: result.Duration().Milliseconds(),
+	}
+	if err != nil {
+		iteration.Error = err.Error()
+	}
+
+	for _, t := range result.Tasks() {
+		record := taskRecord{
+			Type:       "task",
+			Iteration:  i,
+			Name:       t.Name,
+			Plugin:     t.Plugin,
+			Model:      t.Model,
+			Prompt:     t.Prompt,
+			Response:   t.Response,
+			ToolCalls:  t.ToolCalls,
+			DurationMs: t.Duration.Milliseconds(),
+		}
+		if t.Usage.InputTokens > 0 || t.Usage.OutputTokens > 0 {
+			usage := t.Usage
+			record.Usage = &usage
+		}
+		if t.Err != nil {
+			record.Error = t.Err.Error()
+		}
+		iteration.Tasks = append(iteration.Tasks, record)
+	}
+
+	return iteration
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+var durationRegex = regexp.MustCompile(`("duration_ms": ?)\d+`)
+
+// Captures the records and error records written with the output format
+func captureRecords(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+
+	var out bytes.Buffer
+	output := appCfg.Output
+	appCfg.Output = format
+	recordWriter = &out
+	errorRecordWriter = &out
+	t.Cleanup(func() {
+		appCfg.Output = output
+		recordWriter = os.Stdout
+		errorRecordWriter = os.Stderr
+	})
+
+	return &out
+}
+
+// Zeroes the durations in records so they can be compared
+func zeroDurations(s string) string {
+	return durationRegex.ReplaceAllString(s, "${1}0")
+}
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		kind string
+		code int
+	}{
+		{"config", context.Background(), configError(errors.New("bad flag")), "config", exitConfig},
+		{"wrapped config", context.Background(), fmt.Errorf("loading: %w", configError(errors.New("bad flag"))), "config", exitConfig},
+		{"run", context.Background(), runError(errors.New("plugin failed")), "run", exitRun},
+		{"timeout", context.Background(), runError(fmt.Errorf("task: %w", assembllm.ErrTimeout)), "timeout", exitTimeout},
+		{"interrupted", context.Background(), errInterrupted, "interrupted", exitInterrupted},
+		{"cancelled", cancelled, runError(errors.New("plugin failed")), "interrupted", exitInterrupted},
+		{"other", context.Background(), errors.New("unexpected"), "error", exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, code := classifyError(tt.ctx, tt.err)
+			if kind != tt.kind || code != tt.code {
+				t.Fatalf("want %s %d, got %s %d", tt.kind, tt.code, kind, code)
+			}
+		})
+	}
+}
+
+func TestWriteCompletionRecords(t *testing.T) {
+	pc := assembllm.CompletionPluginConfig{Name: "openai", Model: "gpt-4o"}
+	complete := func(prompt string) (string, error) {
+		return "re: " + prompt, nil
+	}
+
+	tests := []struct {
+		format  string
+		prompts []string
+		want    string
+	}{
+		{"json", []string{"hi"}, `{
+  "type": "completion",
+  "plugin": "openai",
+  "model": "gpt-4o",
+  "prompt": "hi",
+  "response": "re: hi",
+  "duration_ms": 0
+}
+`},
+		{"json", []string{"hi", "bye"}, `[
+  {
+    "type": "completion",
+    "plugin": "openai",
+    "model": "gpt-4o",
+    "prompt": "hi",
+    "response": "re: hi",
+    "duration_ms": 0
+  },
+  {
+    "type": "completion",
+    "plugin": "openai",
+    "model": "gpt-4o",
+    "prompt": "bye",
+    "response": "re: bye",
+    "duration_ms": 0
+  }
+]
+`},
+		{"jsonl", []string{"hi", "bye"}, `{"type":"completion","plugin":"openai","model":"gpt-4o","prompt":"hi","response":"re: hi","duration_ms":0}
+{"type":"completion","plugin":"openai","model":"gpt-4o","prompt":"bye","response":"re: bye","duration_ms":0}
+`},
+	}
+
+	for _, tt := range tests {
+		out := captureRecords(t, tt.format)
+		if err := writeCompletionRecords(pc, tt.prompts, complete); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if got := zeroDurations(out.String()); got != tt.want {
+			t.Fatalf("%s: want:\n%s\ngot:\n%s", tt.format, tt.want, got)
+		}
+	}
+
+	// A failed completion is a run error, leaving the error record to the caller
+	out := captureRecords(t, "jsonl")
+	err := writeCompletionRecords(pc, []string{"hi"}, func(string) (string, error) {
+		return "", errors.New("plugin failed")
+	})
+	if kind, code := classifyError(context.Background(), err); kind != "run" || code != exitRun {
+		t.Fatalf("want a run error, got %s %d: %v", kind, code, err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no records, got %q", out.String())
+	}
+}
+
+func TestWriteWorkflowRecords(t *testing.T) {
+	workflowYAML := `tasks:
+  - name: shout
+    prompt: "{{ .input }}"
+    post_script: '"HI"'
+`
+	failingYAML := `tasks:
+  - name: fail
+    prompt: "{{ .input }}"
+    plugin: missing
+`
+
+	tests := []struct {
+		name     string
+		workflow string
+		format   string
+		want     string
+		wantErr  bool
+	}{
+		{"json", workflowYAML, "json", `{
+  "type": "workflow",
+  "workflow": "shout.yaml",
+  "input": "hi",
+  "iterations": [
+    {
+      "type": "iteration",
+      "iteration": 0,
+      "response": "HI",
+      "duration_ms": 0,
+      "tasks": [
+        {
+          "type": "task",
+          "iteration": 0,
+          "name": "shout",
+          "prompt": "hi",
+          "response": "HI",
+          "duration_ms": 0
+        }
+      ]
+    }
+  ],
+  "duration_ms": 0
+}
+`, false},
+		{"jsonl", workflowYAML, "jsonl", `{"type":"task","iteration":0,"name":"shout","prompt":"hi","response":"HI","duration_ms":0}
+{"type":"iteration","iteration":0,"response":"HI","duration_ms":0}
+{"type":"workflow","workflow":"shout.yaml","input":"hi","duration_ms":0}
+`, false},
+		{"jsonl error", failingYAML, "jsonl", `{"type":"task","iteration":0,"name":"fail","plugin":"missing","prompt":"hi","response":"","duration_ms":0,"error":"failed to get plugin info: plugin not found: missing"}
+{"type":"iteration","iteration":0,"response":"","duration_ms":0,"error":"failed to get plugin info: plugin not found: missing"}
+`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureRecords(t, tt.format)
+
+			client := assembllm.NewClient(assembllm.CompletionPluginConfigs{})
+			workflow, err := client.ParseWorkflow([]byte(tt.workflow))
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := workflow.Iterations(context.Background(), "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			results := workflow.StartIterations(context.Background(), "hi", values, 0)
+
+			err = writeWorkflowRecords("shout.yaml", "hi", nil, values, results, time.Now(), func(err error) error { return err })
+			if tt.wantErr {
+				if kind, _ := classifyError(context.Background(), err); kind != "run" {
+					t.Fatalf("want a run error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if got := zeroDurations(out.String()); got != tt.want {
+				t.Fatalf("want:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteErrorRecord(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"json", `{
+  "error": {
+    "kind": "config",
+    "message": "unknown output format: xml, use json or jsonl",
+    "exit_code": 2
+  }
+}
+`},
+		{"jsonl", `{"error":{"kind":"config","message":"unknown output format: xml, use json or jsonl","exit_code":2}}
+`},
+	}
+
+	for _, tt := range tests {
+		out := captureRecords(t, tt.format)
+		writeErrorRecord("config", exitConfig, errors.New("unknown output format: xml, use json or jsonl"))
+		if out.String() != tt.want {
+			t.Fatalf("%s: want:\n%s\ngot:\n%s", tt.format, tt.want, out.String())
+		}
+	}
+}
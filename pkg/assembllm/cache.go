@@ -0,0 +1,206 @@
+package assembllm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An on-disk cache of plugin responses
+// Entries are keyed on the plugin source, model, role, temperature, and the request sent to the plugin
+type ResponseCache struct {
+	Dir string
+	// How long a cached response is used, responses never expire when zero
+	TTL time.Duration
+}
+
+// Summary of the responses stored in a cache
+type CacheStats struct {
+	Entries int
+	// Entries older than the cache's TTL
+	Expired int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+type cacheEntry struct {
+	Created  time.Time `json:"created"`
+	Plugin   string    `json:"plugin"`
+	Model    string    `json:"model,omitempty"`
+	Response string    `json:"response"`
+}
+
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+func (c *ResponseCache) expired(created time.Time) bool {
+	return c.TTL > 0 && time.Since(created) > c.TTL
+}
+
+// Gets the cached response for the key, if there is one that hasn't expired
+func (c *ResponseCache) Get(key string) (string, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return "", false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || c.expired(entry.Created) {
+		return "", false
+	}
+
+	return entry.Response, true
+}
+
+// Stores the response for the key
+func (c *ResponseCache) Put(key string, plugin string, model string, response string) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cacheEntry{Created: time.Now(), Plugin: plugin, Model: model, Response: response})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so parallel iterations never read a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Removes cached responses, only those past the TTL when expiredOnly is set
+// Returns the number of responses removed
+func (c *ResponseCache) Clear(expiredOnly bool) (int, error) {
+	removed := 0
+	err := c.walk(func(path string, entry cacheEntry, _ int64) error {
+		if expiredOnly && !c.expired(entry.Created) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+
+	return removed, err
+}
+
+// Gets the number, size, and age of the cached responses
+func (c *ResponseCache) Stats() (CacheStats, error) {
+	var stats CacheStats
+	err := c.walk(func(_ string, entry cacheEntry, size int64) error {
+		stats.Entries++
+		stats.Bytes += size
+		if c.expired(entry.Created) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || entry.Created.Before(stats.Oldest) {
+			stats.Oldest = entry.Created
+		}
+		if entry.Created.After(stats.Newest) {
+			stats.Newest = entry.Created
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// Calls fn for each cached response, unreadable entries are treated as expired
+func (c *ResponseCache) walk(fn func(path string, entry cacheEntry, size int64) error) error {
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var entry cacheEntry
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &entry)
+		}
+
+		return fn(path, entry, info.Size())
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Gets the cache key for a call to the plugin function with the input
+func (p CompletionPluginConfig) cacheKey(function string, input interface{}) (string, error) {
+	data, err := json.Marshal(struct {
+		Source      string      `json:"source"`
+		Hash        string      `json:"hash"`
+		Model       string      `json:"model"`
+		Role        string      `json:"role"`
+		Temperature string      `json:"temperature"`
+		Function    string      `json:"function"`
+		Input       interface{} `json:"input"`
+	}{p.Source, p.Hash, p.Model, p.Role, p.Temperature, function, input})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Returns the cached response for the call when the plugin has a cache, otherwise makes the call and caches its response
+func (p CompletionPluginConfig) callCached(ctx context.Context, function string, input interface{}, call func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error)) (string, error) {
+	if p.Cache == nil {
+		return p.callWithRetries(ctx, call)
+	}
+
+	key, err := p.cacheKey(function, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache key: %v", err)
+	}
+
+	if res, ok := p.Cache.Get(key); ok {
+		return res, nil
+	}
+
+	res, err := p.callWithRetries(ctx, call)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.Cache.Put(key, p.Name, p.Model, res); err != nil {
+		p.logger().Printf("%s: failed to cache response: %v", p.Name, err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package assembllm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	t.Parallel()
+
+	cache := &ResponseCache{Dir: t.TempDir(), TTL: time.Hour}
+	pluginCfg := CompletionPluginConfig{Name: "openai", Source: "openai.wasm", Model: "gpt-4o", Cache: cache}
+
+	key, err := pluginCfg.cacheKey("completion", "tell me a joke")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatalf("expected a miss before the response is cached")
+	}
+	if err := cache.Put(key, pluginCfg.Name, pluginCfg.Model, "a joke"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got, ok := cache.Get(key); !ok || got != "a joke" {
+		t.Fatalf("want a joke, got %s", got)
+	}
+
+	// A cached response is returned without calling the plugin
+	got, err := pluginCfg.callCached(context.Background(), "completion", "tell me a joke", nil)
+	if err != nil || got != "a joke" {
+		t.Fatalf("want a joke, got %s, %v", got, err)
+	}
+
+	expired := &ResponseCache{Dir: cache.Dir, TTL: time.Nanosecond}
+	time.Sleep(time.Millisecond)
+	if _, ok := expired.Get(key); ok {
+		t.Fatalf("expected an expired entry to miss")
+	}
+
+	stats, err := expired.Stats()
+	if err != nil || stats.Entries != 1 || stats.Expired != 1 {
+		t.Fatalf("want 1 expired entry, got %+v, %v", stats, err)
+	}
+
+	removed, err := cache.Clear(true)
+	if err != nil || removed != 0 {
+		t.Fatalf("want 0 removed, got %d, %v", removed, err)
+	}
+	removed, err = cache.Clear(false)
+	if err != nil || removed != 1 {
+		t.Fatalf("want 1 removed, got %d, %v", removed, err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	t.Parallel()
+
+	base := CompletionPluginConfig{Source: "openai.wasm", Model: "gpt-4o", Role: "poet", Temperature: "0.5"}
+	key, _ := base.cacheKey("completion", "prompt")
+
+	if same, _ := base.cacheKey("completion", "prompt"); same != key {
+		t.Fatalf("expected identical calls to share a key")
+	}
+
+	changed := []CompletionPluginConfig{base, base, base, base}
+	changed[0].Source = "other.wasm"
+	changed[1].Model = "gpt-4"
+	changed[2].Role = "critic"
+	changed[3].Temperature = "0.9"
+	for _, c := range changed {
+		if k, _ := c.cacheKey("completion", "prompt"); k == key {
+			t.Fatalf("expected a different key for %+v", c)
+		}
+	}
+
+	if k, _ := base.cacheKey("completion", "other prompt"); k == key {
+		t.Fatalf("expected a different key for a different prompt")
+	}
+	tools := Request{Tools: []Tool{{Name: "weather"}}, Messages: []Message{{Role: "user", Content: "prompt"}}}
+	if k, _ := base.cacheKey("completionWithTools", tools); k == key {
+		t.Fatalf("expected a different key for a call with tools")
+	}
+}
@@ -0,0 +1,134 @@
+// Package assembllm runs chat completions and task workflows against
+// WebAssembly completion plugins loaded with Extism.
+package assembllm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+)
+
+// Client holds the plugin configurations used for completions and workflows
+type Client struct {
+	Plugins  CompletionPluginConfigs
+	LogLevel extism.LogLevel
+	// Logs retried plugin calls, defaults to the standard logger
+	Logger *log.Logger
+	// Limit on each plugin call, no limit when zero
+	Timeout time.Duration
+	// Called with the token usage of each plugin call, for plugins that report it
+	OnUsage func(usage Usage)
+	// Where responses are cached for tasks that enable caching
+	Cache *ResponseCache
+	// Caches every plugin call, not only those of tasks that enable caching
+	CacheAll bool
+	// Where downloaded and compiled plugin modules are kept between runs, only kept in memory when empty
+	ModuleDir string
+}
+
+// Creates a new client from the plugin configurations
+func NewClient(plugins CompletionPluginConfigs) *Client {
+	return &Client{
+		Plugins:  plugins,
+		LogLevel: extism.LogLevelOff,
+	}
+}
+
+// Creates a new client from yaml plugin configuration
+func NewClientFromConfig(data []byte) (*Client, error) {
+	plugins, err := ParsePluginConfigs(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config from yaml: %v", err)
+	}
+
+	return NewClient(plugins), nil
+}
+
+// Creates a new client from a yaml plugin configuration reader
+func NewClientFromReader(r io.Reader) (*Client, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClientFromConfig(data)
+}
+
+// Gets the configuration for the named plugin
+func (c *Client) Plugin(name string) (CompletionPluginConfig, error) {
+	pluginCfg, err := c.Plugins.GetPlugin(name)
+	if err != nil {
+		return CompletionPluginConfig{}, fmt.Errorf("failed to get plugin info: %v", err)
+	}
+	pluginCfg.LogLevel = c.LogLevel
+	pluginCfg.Logger = c.Logger
+	pluginCfg.OnUsage = c.OnUsage
+	pluginCfg.ModuleDir = c.ModuleDir
+	if c.Timeout > 0 {
+		pluginCfg.Timeout = c.Timeout
+	}
+	if c.CacheAll {
+		pluginCfg.Cache = c.Cache
+	}
+
+	return pluginCfg, nil
+}
+
+// Gets the models supported by the named plugin
+func (c *Client) Models(pluginName string) ([]string, error) {
+	pluginCfg, err := c.Plugin(pluginName)
+	if err != nil {
+		return nil, err
+	}
+
+	return pluginCfg.GetModels()
+}
+
+// Gets the completions response for the prompt from the named plugin
+func (c *Client) Complete(pluginName string, prompt string) (string, error) {
+	return c.CompleteContext(context.Background(), pluginName, prompt)
+}
+
+// Gets the completions response for the prompt, cancelling the call when the context is done
+func (c *Client) CompleteContext(ctx context.Context, pluginName string, prompt string) (string, error) {
+	pluginCfg, err := c.Plugin(pluginName)
+	if err != nil {
+		return "", err
+	}
+
+	return pluginCfg.GenerateResponseContext(ctx, prompt)
+}
+
+// Gets the chat response for the conversation from the named plugin
+func (c *Client) Chat(pluginName string, messages []Message) (string, error) {
+	return c.ChatContext(context.Background(), pluginName, messages)
+}
+
+// Gets the chat response for the conversation, cancelling the call when the context is done
+func (c *Client) ChatContext(ctx context.Context, pluginName string, messages []Message) (string, error) {
+	pluginCfg, err := c.Plugin(pluginName)
+	if err != nil {
+		return "", err
+	}
+
+	return pluginCfg.GenerateChatResponseContext(ctx, messages)
+}
+
+// Runs a yaml workflow with the input, returning the combined output of all iterations
+func (c *Client) RunWorkflow(r io.Reader, input string) (string, error) {
+	return c.RunWorkflowContext(context.Background(), r, input)
+}
+
+// Runs a yaml workflow with the input, stopping when the context is done
+func (c *Client) RunWorkflowContext(ctx context.Context, r io.Reader, input string) (string, error) {
+	w, err := c.LoadWorkflow(r)
+	if err != nil {
+		return "", err
+	}
+
+	return w.RunContext(ctx, input)
+}
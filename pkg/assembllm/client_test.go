@@ -0,0 +1,118 @@
+package assembllm
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+completion-plugins:
+  - name: openai
+    source: https://github.com/bradyjoslin/assembllm-openai/releases/latest/download/assembllm_openai.wasm
+    apiKey: OPENAI_API_KEY
+    url: api.openai.com
+`
+
+func TestNewClientFromConfig(t *testing.T) {
+	t.Parallel()
+
+	client, err := NewClientFromConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	pluginCfg, err := client.Plugin("openai")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if pluginCfg.URL != "api.openai.com" {
+		t.Fatalf("want api.openai.com, got %s", pluginCfg.URL)
+	}
+
+	_, err = client.Plugin("missing")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestRunWorkflowScripts(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+
+	workflow := `
+iterator_script: "['a', 'b']"
+tasks:
+  - name: upper
+    post_script: "upper(iterValue) + '!'"
+`
+
+	got, err := client.RunWorkflow(strings.NewReader(workflow), "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	want := "A!B!"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestRunWorkflowConcurrentIterations(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+
+	workflow := `
+concurrency: 3
+iterator_script: "['a', 'b', 'c', 'd', 'e']"
+tasks:
+  - name: upper
+    post_script: "upper(iterValue)"
+`
+
+	got, err := client.RunWorkflow(strings.NewReader(workflow), "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	want := "ABCDE"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestIterationsAcceptTypedSlices(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+
+	tests := []struct {
+		script string
+		want   []interface{}
+		ok     bool
+	}{
+		{`split(input, ",")`, []interface{}{"a", "b"}, true},
+		{`[1, 2]`, []interface{}{1, 2}, true},
+		{`map([1, 2], # * 2)`, []interface{}{2, 4}, true},
+		{`fromJSON('"a"')`, nil, false},
+	}
+
+	for _, tt := range tests {
+		workflow, err := client.ParseWorkflow([]byte("iterator_script: '" + strings.ReplaceAll(tt.script, "'", "''") + "'\ntasks:\n  - name: a\n"))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		got, err := workflow.Iterations(context.Background(), "a,b")
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: want ok %v, got %v", tt.script, tt.ok, err)
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: want %v, got %v", tt.script, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package assembllm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	extism "github.com/extism/go-sdk"
+)
+
+type Model struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+type CompletionsPlugin struct {
+	Plugin extism.Plugin
+}
+
+type Property struct {
+	Type        string   `json:"type" yaml:"type"`
+	Description string   `json:"description" yaml:"description"`
+	Enum        []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+}
+
+type Schema struct {
+	Type       string              `json:"type" yaml:"type"`
+	Properties map[string]Property `json:"properties" yaml:"properties"`
+	Required   []string            `json:"required" yaml:"required"`
+	// The schema as given by an MCP server, sent in place of the fields above
+	raw json.RawMessage
+}
+
+// Sends schemas from MCP servers as the server described them, since they can use more of JSON Schema than Schema holds
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if len(s.raw) > 0 {
+		return s.raw, nil
+	}
+	type schema Schema
+	return json.Marshal(schema(s))
+}
+
+type Tool struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	InputSchema Schema `json:"input_schema" yaml:"input_schema"`
+	// Optional implementations executed when the model calls the tool
+	Script   string      `json:"-" yaml:"script,omitempty"`
+	Extism   *ExtismTool `json:"-" yaml:"extism,omitempty"`
+	Workflow string      `json:"-" yaml:"workflow,omitempty"`
+	// Set for tools offered by a task's MCP servers
+	mcp *mcpSession
+}
+
+type Message struct {
+	Role    string `json:"role" yaml:"role"`
+	Content string `json:"content" yaml:"content"`
+}
+
+type Request struct {
+	Tools    []Tool    `json:"tools" yaml:"tools"`
+	Messages []Message `json:"messages" yaml:"messages"`
+}
+
+// Get the available models from the completions plugin
+// Listing models doesn't need the plugin's secrets, so ones that aren't set are left empty
+func (pluginCfg CompletionPluginConfig) GetModels() ([]string, error) {
+	ctx := context.Background()
+	pluginCfg = pluginCfg.withAvailableSecrets(ctx)
+
+	plugin, err := pluginCfg.acquirePlugin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize plugin: %v", err)
+	}
+
+	modelNames, err := plugin.getModelNames()
+	pluginCfg.releasePlugin(plugin, err == nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get models: %v", err)
+	}
+
+	return modelNames, nil
+}
+
+// Get the tool calling response for the prompt from the completions plugin
+func (pluginInfo CompletionPluginConfig) GenerateResponseWithTools(prompt string, tools []Tool) (string, error) {
+	return pluginInfo.GenerateResponseWithToolsContext(context.Background(), prompt, tools)
+}
+
+// Get the tool calling response for the prompt, cancelling the call when the context is done
+func (pluginInfo CompletionPluginConfig) GenerateResponseWithToolsContext(ctx context.Context, prompt string, tools []Tool) (string, error) {
+	return pluginInfo.GenerateResponseWithMessagesContext(ctx, []Message{{Role: "user", Content: prompt}}, tools)
+}
+
+// Get the tool calling response for the conversation from the completions plugin
+func (pluginInfo CompletionPluginConfig) GenerateResponseWithMessages(messages []Message, tools []Tool) (string, error) {
+	return pluginInfo.GenerateResponseWithMessagesContext(context.Background(), messages, tools)
+}
+
+// Get the tool calling response for the conversation, cancelling the call when the context is done
+func (pluginInfo CompletionPluginConfig) GenerateResponseWithMessagesContext(ctx context.Context, messages []Message, tools []Tool) (string, error) {
+	return pluginInfo.callCached(ctx, "completionWithTools", Request{Tools: tools, Messages: messages}, func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error) {
+		_, out, err := plugin.completionWithTools(ctx, messages, tools)
+		return out, err
+	})
+}
+
+// Get completions response for the prompt from the completions plugin
+func (pluginInfo CompletionPluginConfig) GenerateResponse(prompt string) (string, error) {
+	return pluginInfo.GenerateResponseContext(context.Background(), prompt)
+}
+
+// Get completions response for the prompt, cancelling the call when the context is done
+func (pluginInfo CompletionPluginConfig) GenerateResponseContext(ctx context.Context, prompt string) (string, error) {
+	return pluginInfo.callCached(ctx, "completion", prompt, func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error) {
+		_, out, err := plugin.completion(ctx, prompt)
+		return out, err
+	})
+}
+
+// Get the chat response for the conversation from the completions plugin
+// Plugins that don't export chat receive the conversation flattened into a single prompt
+func (pluginInfo CompletionPluginConfig) GenerateChatResponse(messages []Message) (string, error) {
+	return pluginInfo.GenerateChatResponseContext(context.Background(), messages)
+}
+
+// Get the chat response for the conversation, cancelling the call when the context is done
+func (pluginInfo CompletionPluginConfig) GenerateChatResponseContext(ctx context.Context, messages []Message) (string, error) {
+	return pluginInfo.callCached(ctx, "chat", messages, func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error) {
+		if plugin.Plugin.FunctionExists("chat") {
+			_, out, err := plugin.chat(ctx, messages)
+			return out, err
+		}
+		_, out, err := plugin.completion(ctx, flattenMessages(messages))
+		return out, err
+	})
+}
+
+// Call an exposed Extism function on the completions plugin
+func (p *CompletionsPlugin) Call(method string, payload []byte) (uint32, []byte, error) {
+	return p.CallWithContext(context.Background(), method, payload)
+}
+
+// Call an exposed Extism function on the completions plugin, cancelling the call when the context is done
+func (p *CompletionsPlugin) CallWithContext(ctx context.Context, method string, payload []byte) (uint32, []byte, error) {
+	return p.Plugin.CallWithContext(ctx, method, payload)
+}
+
+// Create a new completions extism plugin from the configuration
+func (p CompletionPluginConfig) CreatePlugin() (CompletionsPlugin, error) {
+	return p.CreatePluginContext(context.Background())
+}
+
+// Create a new completions extism plugin from the configuration
+// The context bounds downloading the plugin, and calls made with a context are closed when it is done
+func (p CompletionPluginConfig) CreatePluginContext(ctx context.Context) (CompletionsPlugin, error) {
+	p, err := p.withSecrets(ctx)
+	if err != nil {
+		return CompletionsPlugin{}, err
+	}
+
+	return p.createPlugin(ctx)
+}
+
+// Creates the plugin from a configuration whose secrets are already resolved
+func (p CompletionPluginConfig) createPlugin(ctx context.Context) (CompletionsPlugin, error) {
+	wasm, err := p.wasm(ctx)
+	if err != nil {
+		return CompletionsPlugin{}, err
+	}
+
+	manifest := extism.Manifest{
+		Wasm: []extism.Wasm{
+			wasm,
+		},
+	}
+
+	plugin, err := extism.NewPlugin(
+		ctx,
+		manifest,
+		extism.PluginConfig{
+			EnableWasi:    p.Wasi,
+			RuntimeConfig: p.runtimeConfig(),
+		},
+		[]extism.HostFunction{p.emitChunk()},
+	)
+	if err != nil {
+		return CompletionsPlugin{}, err
+	}
+	if plugin == nil {
+		return CompletionsPlugin{}, fmt.Errorf("plugin is nil")
+	}
+
+	completionsPlugin := CompletionsPlugin{*plugin}
+	p.configure(&completionsPlugin)
+	return completionsPlugin, nil
+}
+
+// Sets the plugin's allowed hosts, configuration, and logging from the configuration, with its secrets resolved
+func (p CompletionPluginConfig) configure(plugin *CompletionsPlugin) {
+	plugin.Plugin.AllowedHosts = []string{p.URL}
+	plugin.Plugin.Config = map[string]string{"api_key": p.APIKey, "model": p.Model, "temperature": p.Temperature, "role": p.Role, "account_id": p.AccountId, "envelope": "true"}
+
+	plugin.Plugin.SetLogLevel(p.LogLevel)
+	plugin.Plugin.SetLogger(func(level extism.LogLevel, message string) {
+		fmt.Printf("[%s] %s\n", level, message)
+	})
+}
+
+type chunkHandlerKey struct{}
+
+// Sends chunks emitted during calls made with the context to the handler
+func withChunkHandler(ctx context.Context, onChunk func(chunk string)) context.Context {
+	return context.WithValue(ctx, chunkHandlerKey{}, onChunk)
+}
+
+// Host function plugins can import to stream response chunks as they are generated
+// Chunks go to the handler in the call's context, or the configuration's OnChunk callback
+// Plugins that don't import it are unaffected
+func (p CompletionPluginConfig) emitChunk() extism.HostFunction {
+	return extism.NewHostFunctionWithStack(
+		"emit_chunk",
+		func(ctx context.Context, plugin *extism.CurrentPlugin, stack []uint64) {
+			chunk, err := plugin.ReadString(stack[0])
+			if err != nil {
+				plugin.Logf(extism.LogLevelError, "failed to read chunk: %v", err)
+				return
+			}
+
+			onChunk := p.OnChunk
+			if handler, ok := ctx.Value(chunkHandlerKey{}).(func(chunk string)); ok {
+				onChunk = handler
+			}
+			if onChunk != nil {
+				onChunk(chunk)
+			}
+		},
+		[]extism.ValueType{extism.ValueTypePTR},
+		[]extism.ValueType{},
+	)
+}
+
+// Accumulates the chunks streamed during a call
+type chunkCollector struct {
+	sb strings.Builder
+}
+
+// Wraps the OnChunk callback to also accumulate streamed chunks
+func (p *CompletionPluginConfig) collectChunks() *chunkCollector {
+	c := &chunkCollector{}
+	onChunk := p.OnChunk
+	p.OnChunk = func(chunk string) {
+		c.sb.WriteString(chunk)
+		if onChunk != nil {
+			onChunk(chunk)
+		}
+	}
+	return c
+}
+
+// Uses the streamed chunks as the response when the plugin returned no output
+func (c *chunkCollector) response(out []byte) string {
+	if len(out) == 0 {
+		return c.sb.String()
+	}
+	return string(out)
+}
+
+// Get list of supported models
+func (plugin *CompletionsPlugin) models() (uint32, []byte, error) {
+	return plugin.Call("models", []byte{})
+}
+
+// Get completions for the prompt
+func (plugin *CompletionsPlugin) completion(ctx context.Context, prompt string) (uint32, []byte, error) {
+	return plugin.CallWithContext(ctx, "completion", []byte(prompt))
+}
+
+func (plugin *CompletionsPlugin) completionWithTools(ctx context.Context, messages []Message, tools []Tool) (uint32, []byte, error) {
+	request := Request{
+		Tools:    tools,
+		Messages: messages,
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return plugin.CallWithContext(ctx, "completionWithTools", data)
+}
+
+// Get the chat response for the conversation history
+func (plugin *CompletionsPlugin) chat(ctx context.Context, messages []Message) (uint32, []byte, error) {
+	request := Request{
+		Messages: messages,
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return plugin.CallWithContext(ctx, "chat", data)
+}
+
+// Flattens a conversation into a single prompt for plugins that only support completion
+func flattenMessages(messages []Message) string {
+	if len(messages) == 1 {
+		return messages[0].Content
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Continue this conversation, replying only as the assistant to the last user message.\n\n")
+	for _, m := range messages {
+		sb.WriteString(m.Role + ": " + m.Content + "\n\n")
+	}
+
+	return sb.String()
+}
+
+// Get the available models from the completions plugin
+func (plugin CompletionsPlugin) getModelNames() ([]string, error) {
+	_, jsonMs, err := plugin.models()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get models: %v", err)
+	}
+	var models []Model
+	err = json.Unmarshal([]byte(jsonMs), &models)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal models: %v", err)
+	}
+
+	var modelNames []string
+	for _, model := range models {
+		modelNames = append(modelNames, model.Name)
+	}
+	return modelNames, nil
+}
+
+// Check if the path is a file
+func isFilePath(s string) bool {
+	info, err := os.Stat(s)
+	return !os.IsNotExist(err) && !info.IsDir()
+}
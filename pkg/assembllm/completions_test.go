@@ -0,0 +1,174 @@
+package assembllm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A module exporting completion and models, but not chat, which echo their input as the output
+var echoCompletionModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// Types: () -> i64, (i64, i64) -> (), () -> i32
+	0x01, 0x0e, 0x03,
+	0x60, 0x00, 0x01, 0x7e,
+	0x60, 0x02, 0x7e, 0x7e, 0x00,
+	0x60, 0x00, 0x01, 0x7f,
+	// Imports: input_offset, input_length, and output_set from extism:host/env
+	0x02, 0x5c, 0x03,
+	0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+	0x0c, 'i', 'n', 'p', 'u', 't', '_', 'o', 'f', 'f', 's', 'e', 't', 0x00, 0x00,
+	0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+	0x0c, 'i', 'n', 'p', 'u', 't', '_', 'l', 'e', 'n', 'g', 't', 'h', 0x00, 0x00,
+	0x0f, 'e', 'x', 't', 'i', 's', 'm', ':', 'h', 'o', 's', 't', '/', 'e', 'n', 'v',
+	0x0a, 'o', 'u', 't', 'p', 'u', 't', '_', 's', 'e', 't', 0x00, 0x01,
+	// Functions: echo () -> i32
+	0x03, 0x02, 0x01, 0x02,
+	// Exports: echo as completion and models
+	0x07, 0x17, 0x02,
+	0x0a, 'c', 'o', 'm', 'p', 'l', 'e', 't', 'i', 'o', 'n', 0x00, 0x03,
+	0x06, 'm', 'o', 'd', 'e', 'l', 's', 0x00, 0x03,
+	// Code: output_set(input_offset(), input_length()); return 0
+	0x0a, 0x0c, 0x01,
+	0x0a, 0x00, 0x10, 0x00, 0x10, 0x01, 0x10, 0x02, 0x41, 0x00, 0x0b,
+}
+
+func TestFlattenMessages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     string
+	}{
+		{
+			name:     "single message",
+			messages: []Message{{Role: "user", Content: "hello"}},
+			want:     "hello",
+		},
+		{
+			name: "conversation",
+			messages: []Message{
+				{Role: "user", Content: "hello"},
+				{Role: "assistant", Content: "hi there"},
+				{Role: "user", Content: "tell me a joke"},
+			},
+			want: "Continue this conversation, replying only as the assistant to the last user message.\n\n" +
+				"user: hello\n\nassistant: hi there\n\nuser: tell me a joke\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flattenMessages(tt.messages); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestChatFallsBackToCompletion(t *testing.T) {
+	t.Parallel()
+
+	source := filepath.Join(t.TempDir(), "echo.wasm")
+	if err := os.WriteFile(source, echoCompletionModule, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pluginCfg := CompletionPluginConfig{Name: "echo", Source: source}
+
+	messages := []Message{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "hi there"},
+		{Role: "user", Content: "tell me a joke"},
+	}
+
+	// The plugin doesn't export chat, so it receives the flattened conversation
+	got, err := pluginCfg.GenerateChatResponse(messages)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if want := flattenMessages(messages); got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+// Builds a module whose exported functions all output text, copying it from a data segment to extism memory
+func constantModule(text string, exports ...string) []byte {
+	uleb := func(n int) []byte {
+		var b []byte
+		for {
+			c := byte(n & 0x7f)
+			n >>= 7
+			if n != 0 {
+				c |= 0x80
+			}
+			b = append(b, c)
+			if n == 0 {
+				return b
+			}
+		}
+	}
+	name := func(s string) []byte {
+		return append(uleb(len(s)), s...)
+	}
+	section := func(id byte, count int, entries ...[]byte) []byte {
+		content := uleb(count)
+		for _, e := range entries {
+			content = append(content, e...)
+		}
+		return append(append([]byte{id}, uleb(len(content))...), content...)
+	}
+	// i64.const with a signed LEB128 value, only non-negative values below 2^62 are needed here
+	i64 := func(n int) []byte {
+		b := []byte{0x42}
+		for {
+			c := byte(n & 0x7f)
+			n >>= 7
+			if n == 0 && c&0x40 == 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	imp := func(field string, typ byte) []byte {
+		return append(append(name("extism:host/env"), name(field)...), 0x00, typ)
+	}
+
+	var body []byte
+	body = append(body, 0x01, 0x02, 0x7e) // locals: offset, i
+	body = append(body, i64(len(text))...)
+	body = append(body, 0x10, 0x00, 0x21, 0x00) // offset = alloc(len)
+	body = append(body, 0x02, 0x40, 0x03, 0x40) // block, loop
+	body = append(body, 0x20, 0x01)
+	body = append(body, i64(len(text))...)
+	body = append(body, 0x5a, 0x0d, 0x01) // break when i >= len
+	body = append(body, 0x20, 0x00, 0x20, 0x01, 0x7c)
+	body = append(body, 0x20, 0x01, 0xa7, 0x2d, 0x00, 0x00) // load the byte at i
+	body = append(body, 0x10, 0x01)                         // store_u8(offset + i, byte)
+	body = append(body, 0x20, 0x01)
+	body = append(body, i64(1)...)
+	body = append(body, 0x7c, 0x21, 0x01, 0x0c, 0x00, 0x0b, 0x0b) // i++, loop
+	body = append(body, 0x20, 0x00)
+	body = append(body, i64(len(text))...)
+	body = append(body, 0x10, 0x02, 0x41, 0x00, 0x0b) // output_set(offset, len), return 0
+
+	var exportEntries [][]byte
+	for _, e := range exports {
+		exportEntries = append(exportEntries, append(name(e), 0x00, 0x03))
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(0x01, 4,
+		[]byte{0x60, 0x01, 0x7e, 0x01, 0x7e}, // alloc
+		[]byte{0x60, 0x02, 0x7e, 0x7f, 0x00}, // store_u8
+		[]byte{0x60, 0x02, 0x7e, 0x7e, 0x00}, // output_set
+		[]byte{0x60, 0x00, 0x01, 0x7f})...)   // exports
+	module = append(module, section(0x02, 3, imp("alloc", 0), imp("store_u8", 1), imp("output_set", 2))...)
+	module = append(module, section(0x03, 1, []byte{0x03})...)
+	module = append(module, section(0x05, 1, []byte{0x00, 0x01})...)
+	module = append(module, section(0x07, len(exports), exportEntries...)...)
+	module = append(module, section(0x0a, 1, append(uleb(len(body)), body...))...)
+	module = append(module, section(0x0b, 1, append([]byte{0x00, 0x41, 0x00, 0x0b}, name(text)...))...)
+
+	return module
+}
@@ -0,0 +1,81 @@
+package assembllm
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	"gopkg.in/yaml.v3"
+)
+
+type CompletionPluginConfig struct {
+	Name   string `yaml:"name"`
+	Source string `yaml:"source"`
+	Hash   string `yaml:"hash"`
+	// Secret references such as env:NAME, file:/path, or cmd:command, resolved when the plugin is used
+	APIKey      string `yaml:"apiKey"`
+	AccountId   string `yaml:"accountId"`
+	URL         string `yaml:"url"`
+	Model       string `yaml:"model"`
+	Temperature string `yaml:"temperature"`
+	Role        string `yaml:"role"`
+	Wasi        bool   `yaml:"wasi"`
+	RetryPolicy `yaml:",inline"`
+	// Prices keyed by model name, with default used for unlisted models
+	Pricing  map[string]Price `yaml:"pricing,omitempty"`
+	LogLevel extism.LogLevel  `yaml:"-"`
+	// Limit on each call to the plugin, no limit when zero
+	Timeout time.Duration `yaml:"-"`
+	// Logs retried calls, defaults to the standard logger
+	Logger *log.Logger `yaml:"-"`
+	// Called with each chunk a streaming plugin emits while generating a response
+	OnChunk func(chunk string) `yaml:"-"`
+	// Called with the token usage of each call, for plugins that report it
+	OnUsage func(usage Usage) `yaml:"-"`
+	// Stores responses and reuses them for identical calls, no caching when nil
+	Cache *ResponseCache `yaml:"-"`
+	// Where downloaded and compiled modules are kept between runs, only kept in memory when empty
+	ModuleDir string `yaml:"-"`
+}
+
+type CompletionPluginConfigs struct {
+	Plugins []CompletionPluginConfig `yaml:"completion-plugins"`
+}
+
+// Parses the available chat completion plugins from yaml
+func ParsePluginConfigs(data []byte) (CompletionPluginConfigs, error) {
+	var completionPluginConfigs CompletionPluginConfigs
+	err := yaml.Unmarshal(data, &completionPluginConfigs)
+	if err != nil {
+		return CompletionPluginConfigs{}, err
+	}
+
+	return completionPluginConfigs, nil
+}
+
+// Loads the available chat completion plugins from a yaml reader
+func LoadPluginConfigs(r io.Reader) (CompletionPluginConfigs, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return CompletionPluginConfigs{}, err
+	}
+
+	return ParsePluginConfigs(data)
+}
+
+// Get a plugin configuration from the available plugins
+func (plugins CompletionPluginConfigs) GetPlugin(pluginName string) (CompletionPluginConfig, error) {
+	var pluginInfo CompletionPluginConfig
+	for _, p := range plugins.Plugins {
+		if p.Name == pluginName {
+			pluginInfo = p
+			break
+		}
+	}
+	if pluginInfo.Name == "" {
+		return CompletionPluginConfig{}, fmt.Errorf("plugin not found: %s", pluginName)
+	}
+	return pluginInfo, nil
+}
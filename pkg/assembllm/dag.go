@@ -0,0 +1,208 @@
+package assembllm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Check if any task declares dependencies, which runs the tasks as a graph
+func (tasks Tasks) hasDependencies() bool {
+	for _, t := range tasks.Tasks {
+		if len(t.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Sorts the tasks so each comes after the tasks it depends on, returning their indexes
+// Unknown dependencies and cycles are rejected
+func (tasks Tasks) topologicalOrder() ([]int, error) {
+	index := map[string]int{}
+	for i, t := range tasks.Tasks {
+		if t.Name == "" {
+			continue
+		}
+		if _, ok := index[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate task name: %s", t.Name)
+		}
+		index[t.Name] = i
+	}
+
+	inDegree := make([]int, len(tasks.Tasks))
+	dependents := make([][]int, len(tasks.Tasks))
+	for i, t := range tasks.Tasks {
+		for _, dep := range t.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("task %s depends on unknown task: %s", t.Name, dep)
+			}
+			inDegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready, order []int
+	for i := range tasks.Tasks {
+		if inDegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+		for _, j := range dependents[i] {
+			inDegree[j]--
+			if inDegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	if len(order) != len(tasks.Tasks) {
+		var cycle []string
+		for i, t := range tasks.Tasks {
+			if inDegree[i] > 0 {
+				cycle = append(cycle, t.Name)
+			}
+		}
+		return nil, fmt.Errorf("cycle detected in task dependencies: %s", strings.Join(cycle, ", "))
+	}
+
+	return order, nil
+}
+
+// Names of every task upstream of the task at index i
+func (tasks Tasks) ancestors(i int) map[string]bool {
+	index := map[string]int{}
+	for j, t := range tasks.Tasks {
+		index[t.Name] = j
+	}
+
+	seen := map[string]bool{}
+	stack := append([]string{}, tasks.Tasks[i].DependsOn...)
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		stack = append(stack, tasks.Tasks[index[name]].DependsOn...)
+	}
+
+	return seen
+}
+
+// A task in the graph, done is closed once it has run or been skipped
+type pendingTask struct {
+	done   chan struct{}
+	ran    bool
+	result TaskResult
+	err    error
+}
+
+// Runs the tasks as a dependency graph, running independent tasks concurrently
+// Tasks without dependencies receive the input, others receive their dependencies' outputs
+// Returns the output of the last declared task that no other task depends on, and the results of the tasks that ran
+func (w *Workflow) runGraph(sc scriptContext, input string) (string, []TaskResult, error) {
+	if _, err := w.Tasks.topologicalOrder(); err != nil {
+		return "", nil, err
+	}
+
+	tasks := w.Tasks.Tasks
+	results := map[string]*pendingTask{}
+	all := make([]*pendingTask, len(tasks))
+	for i, t := range tasks {
+		all[i] = &pendingTask{done: make(chan struct{})}
+		if t.Name != "" {
+			results[t.Name] = all[i]
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func(i int, task Task) {
+			defer wg.Done()
+			pending := all[i]
+			defer close(pending.done)
+
+			var prev []string
+			for _, dep := range task.DependsOn {
+				upstream := results[dep]
+				<-upstream.done
+				if upstream.err != nil {
+					pending.err = fmt.Errorf("task %s skipped, dependency %s failed", task.Name, dep)
+					return
+				}
+				prev = append(prev, upstream.result.Response)
+			}
+
+			// Upstream tasks have finished, so their outputs can be read safely
+			taskSc := sc
+			taskSc.outputs = map[string]string{}
+			for name := range w.Tasks.ancestors(i) {
+				taskSc.outputs[name] = results[name].result.Response
+			}
+
+			taskInput := ""
+			if len(task.DependsOn) == 0 {
+				taskInput = input
+			}
+
+			pending.result = w.runTask(taskSc, task, taskInput, strings.Join(prev, "\n\n"))
+			pending.err = pending.result.Err
+			pending.ran = true
+		}(i, task)
+	}
+	wg.Wait()
+
+	var ran []TaskResult
+	for _, pending := range all {
+		if pending.ran {
+			ran = append(ran, pending.result)
+		}
+	}
+
+	// Report the failure of the first task to fail rather than the tasks it caused to be skipped
+	for i, pending := range all {
+		if pending.err != nil && !isSkipped(tasks, all, i) {
+			return "", ran, pending.err
+		}
+	}
+
+	return all[w.Tasks.finalTask()].result.Response, ran, nil
+}
+
+// Index of the last declared task that no other task depends on
+func (tasks Tasks) finalTask() int {
+	upstream := map[string]bool{}
+	for _, t := range tasks.Tasks {
+		for _, dep := range t.DependsOn {
+			upstream[dep] = true
+		}
+	}
+
+	for i := len(tasks.Tasks) - 1; i >= 0; i-- {
+		if !upstream[tasks.Tasks[i].Name] {
+			return i
+		}
+	}
+	return len(tasks.Tasks) - 1
+}
+
+// Check if the task at index i failed only because one of its dependencies did
+func isSkipped(tasks []Task, all []*pendingTask, i int) bool {
+	for _, dep := range tasks[i].DependsOn {
+		for j, t := range tasks {
+			if t.Name == dep && all[j].err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
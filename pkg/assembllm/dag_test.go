@@ -0,0 +1,61 @@
+package assembllm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunWorkflowGraph(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+
+	workflow := `
+tasks:
+  - name: join
+    depends_on: [first, second]
+    post_script: "outputs.first + outputs.second + outputs.root"
+  - name: first
+    depends_on: [root]
+    post_script: "'A'"
+  - name: second
+    depends_on: [root]
+    post_script: "'B'"
+  - name: root
+    post_script: "'C'"
+`
+
+	got, err := client.RunWorkflow(strings.NewReader(workflow), "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	want := "ABC"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestTopologicalOrderRejectsInvalidGraphs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]Tasks{
+		"cycle": {Tasks: []Task{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a"}},
+		}},
+		"unknown": {Tasks: []Task{
+			{Name: "a", DependsOn: []string{"missing"}},
+		}},
+		"duplicate": {Tasks: []Task{
+			{Name: "a"},
+			{Name: "a", DependsOn: []string{"a"}},
+		}},
+	}
+
+	for name, tasks := range tests {
+		if _, err := tasks.topologicalOrder(); err == nil {
+			t.Fatalf("%s: expected error, got nil", name)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package assembllm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Reported when a workflow's inputs are unknown, missing, or of the wrong type
+var ErrInvalidInputs = errors.New("invalid inputs")
+
+var (
+	// Input names are usable as {{ .inputs.name }} in prompts and inputs.name in scripts
+	inputNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	inputTypes = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true}
+)
+
+// A named parameter of a workflow, available to its scripts and prompts as inputs
+type Input struct {
+	Name string `yaml:"name"`
+	// One of string, number, integer, or boolean, defaults to string
+	Type        string      `yaml:"type,omitempty"`
+	Default     interface{} `yaml:"default,omitempty"`
+	Required    bool        `yaml:"required,omitempty"`
+	Description string      `yaml:"description,omitempty"`
+}
+
+// Gets the input's type, string when it doesn't set one
+func (in Input) Kind() string {
+	if in.Type == "" {
+		return "string"
+	}
+	return in.Type
+}
+
+// Converts a value to the input's type, parsing strings as given on a command line
+func (in Input) Convert(value interface{}) (interface{}, error) {
+	switch in.Kind() {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case bool, int, int64, float64:
+			return fmt.Sprint(v), nil
+		}
+	case "number":
+		switch v := value.(type) {
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("input %s must be a number, got %q", in.Name, v)
+			}
+			return f, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "integer":
+		switch v := value.(type) {
+		case string:
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("input %s must be an integer, got %q", in.Name, v)
+			}
+			return i, nil
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("input %s must be true or false, got %q", in.Name, v)
+			}
+			return b, nil
+		case bool:
+			return v, nil
+		}
+	default:
+		return nil, fmt.Errorf("input %s has invalid type %q", in.Name, in.Type)
+	}
+
+	return nil, fmt.Errorf("input %s must be a %s, got %v", in.Name, in.Kind(), value)
+}
+
+// The value of an optional input that isn't set and has no default
+func (in Input) zero() interface{} {
+	switch in.Kind() {
+	case "number":
+		return 0.0
+	case "integer":
+		return 0
+	case "boolean":
+		return false
+	}
+	return ""
+}
+
+// Checks and converts values for the workflow's inputs, filling in defaults
+// Unknown names, values of the wrong type, and missing required inputs are reported with ErrInvalidInputs
+func (w *Workflow) SetInputs(values map[string]interface{}) error {
+	resolved, err := w.Tasks.resolveInputs(values)
+	if err != nil {
+		return err
+	}
+
+	w.Inputs = resolved
+	return nil
+}
+
+// Gets the required inputs that have no value or default
+func (w *Workflow) MissingInputs(values map[string]interface{}) []Input {
+	var missing []Input
+	for _, in := range w.Tasks.Inputs {
+		if _, ok := values[in.Name]; !ok && in.Required && in.Default == nil {
+			missing = append(missing, in)
+		}
+	}
+	return missing
+}
+
+// Gets the values of the workflow's inputs, the defaults when SetInputs wasn't called
+func (w *Workflow) inputs() (map[string]interface{}, error) {
+	if w.Inputs != nil {
+		return w.Inputs, nil
+	}
+	return w.Tasks.resolveInputs(nil)
+}
+
+func (tasks Tasks) resolveInputs(values map[string]interface{}) (map[string]interface{}, error) {
+	declared := map[string]bool{}
+	for _, in := range tasks.Inputs {
+		declared[in.Name] = true
+	}
+
+	var unknown []string
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: unknown input: %s", ErrInvalidInputs, unknown[0])
+	}
+
+	resolved := map[string]interface{}{}
+	for _, in := range tasks.Inputs {
+		value, ok := values[in.Name]
+		if !ok {
+			value = in.Default
+		}
+
+		if value == nil {
+			if in.Required {
+				return nil, fmt.Errorf("%w: missing required input: %s", ErrInvalidInputs, in.Name)
+			}
+			resolved[in.Name] = in.zero()
+			continue
+		}
+
+		converted, err := in.Convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInputs, err)
+		}
+		resolved[in.Name] = converted
+	}
+
+	return resolved, nil
+}
+
+// Checks an input's declaration
+func validateInput(in Input) []string {
+	var problems []string
+
+	if !inputNameRegex.MatchString(in.Name) {
+		problems = append(problems, fmt.Sprintf("invalid name %q, use letters, digits, and underscores", in.Name))
+	}
+	if in.Name == "input" {
+		// Reserved for the prompt input when a workflow is run as an MCP tool or over the HTTP API
+		problems = append(problems, "the name input is reserved, choose another")
+	}
+	if !inputTypes[in.Kind()] {
+		problems = append(problems, fmt.Sprintf("invalid type %q, use string, number, integer, or boolean", in.Type))
+	} else if in.Default != nil {
+		if _, err := in.Convert(in.Default); err != nil {
+			problems = append(problems, fmt.Sprintf("default: %v", err))
+		}
+	}
+
+	return problems
+}
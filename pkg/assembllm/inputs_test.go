@@ -0,0 +1,148 @@
+package assembllm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const inputsWorkflow = `
+inputs:
+  - name: recipient
+    required: true
+  - name: tone
+    default: friendly
+  - name: words
+    type: integer
+    default: 50
+  - name: urgent
+    type: boolean
+tasks:
+  - name: email
+    prompt: "Write to {{ .inputs.recipient }} in a {{ .inputs.tone }} tone"
+    post_script: "inputs.recipient + ' ' + string(inputs.words + 1) + ' ' + string(inputs.urgent)"
+`
+
+func TestSetInputs(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+	workflow, err := client.ParseWorkflow([]byte(inputsWorkflow))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	missing := workflow.MissingInputs(map[string]interface{}{"tone": "formal"})
+	if len(missing) != 1 || missing[0].Name != "recipient" {
+		t.Fatalf("want recipient missing, got %v", missing)
+	}
+
+	tests := []struct {
+		values map[string]interface{}
+		want   map[string]interface{}
+		ok     bool
+	}{
+		{
+			map[string]interface{}{"recipient": "Ana", "words": "80", "urgent": "true"},
+			map[string]interface{}{"recipient": "Ana", "tone": "friendly", "words": 80, "urgent": true},
+			true,
+		},
+		{
+			map[string]interface{}{"recipient": "Ana", "words": 80.0, "tone": "formal"},
+			map[string]interface{}{"recipient": "Ana", "tone": "formal", "words": 80, "urgent": false},
+			true,
+		},
+		{map[string]interface{}{"tone": "formal"}, nil, false},
+		{map[string]interface{}{"recipient": "Ana", "words": "many"}, nil, false},
+		{map[string]interface{}{"recipient": "Ana", "words": 1.5}, nil, false},
+		{map[string]interface{}{"recipient": "Ana", "topic": "lunch"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		err := workflow.SetInputs(tt.values)
+		if (err == nil) != tt.ok {
+			t.Fatalf("%v: want ok %v, got %v", tt.values, tt.ok, err)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidInputs) {
+				t.Fatalf("want ErrInvalidInputs, got %v", err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(workflow.Inputs, tt.want) {
+			t.Fatalf("want %v, got %v", tt.want, workflow.Inputs)
+		}
+	}
+}
+
+func TestRunWorkflowInputs(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+	workflow, err := client.ParseWorkflow([]byte(inputsWorkflow))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if _, err := workflow.Run(""); !errors.Is(err, ErrInvalidInputs) {
+		t.Fatalf("want ErrInvalidInputs, got %v", err)
+	}
+
+	if err := workflow.SetInputs(map[string]interface{}{"recipient": "Ana"}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	values, err := workflow.Iterations(context.Background(), "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	results := workflow.StartIterations(context.Background(), "", values, 0)
+	res, err := results[0].Wait()
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if want := "Ana 51 false"; res != want {
+		t.Fatalf("want %q, got %q", want, res)
+	}
+	if want := "Write to Ana in a friendly tone"; results[0].Tasks()[0].Prompt != want {
+		t.Fatalf("want %q, got %q", want, results[0].Tasks()[0].Prompt)
+	}
+}
+
+func TestWorkflowChainingInputs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "email.yaml"), []byte(inputsWorkflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := `
+tasks:
+  - name: chain
+    post_script: "Workflow('email.yaml', '', {'recipient': 'Bo', 'words': 9})"
+`
+	path := filepath.Join(dir, "parent.yaml")
+	if err := os.WriteFile(path, []byte(parent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(CompletionPluginConfigs{})
+	workflow, err := client.LoadWorkflowFile(path)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	got, err := workflow.Run("")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if want := "Bo 10 false"; got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
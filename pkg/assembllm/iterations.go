@@ -0,0 +1,86 @@
+package assembllm
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var errIterationSkipped = errors.New("iteration skipped after an earlier iteration failed")
+
+// The pending result of a single workflow iteration
+type IterationResult struct {
+	done     chan struct{}
+	output   string
+	err      error
+	tasks    []TaskResult
+	duration time.Duration
+}
+
+// Blocks until the iteration has finished, returning its output
+func (r *IterationResult) Wait() (string, error) {
+	<-r.done
+	return r.output, r.err
+}
+
+// Blocks until the iteration has finished, returning the results of the tasks that ran in declaration order
+func (r *IterationResult) Tasks() []TaskResult {
+	<-r.done
+	return r.tasks
+}
+
+// Blocks until the iteration has finished, returning how long it ran
+func (r *IterationResult) Duration() time.Duration {
+	<-r.done
+	return r.duration
+}
+
+// Starts running the iterations, with up to concurrency running at once
+// A concurrency of zero uses the workflow's concurrency setting, running one at a time if unset
+// Results are returned in the order of the values, iterations not yet started when one fails are skipped
+// The workflow's timeout is not applied, see WithTimeout
+func (w *Workflow) StartIterations(ctx context.Context, input string, values []interface{}, concurrency int) []*IterationResult {
+	if concurrency <= 0 {
+		concurrency = w.Tasks.Concurrency
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]*IterationResult, len(values))
+	for i := range values {
+		results[i] = &IterationResult{done: make(chan struct{})}
+	}
+
+	var failed atomic.Bool
+	sem := make(chan struct{}, concurrency)
+
+	go func() {
+		for i, v := range values {
+			sem <- struct{}{}
+			result := results[i]
+
+			if failed.Load() {
+				result.err = errIterationSkipped
+				close(result.done)
+				<-sem
+				continue
+			}
+
+			go func(v interface{}) {
+				defer func() { <-sem }()
+				defer close(result.done)
+
+				start := time.Now()
+				result.output, result.tasks, result.err = w.runIteration(ctx, input, v)
+				result.duration = time.Since(start)
+				if result.err != nil {
+					failed.Store(true)
+				}
+			}(v)
+		}
+	}()
+
+	return results
+}
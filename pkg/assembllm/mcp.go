@@ -0,0 +1,526 @@
+package assembllm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The Model Context Protocol version requested from servers
+	mcpProtocolVersion = "2025-03-26"
+
+	// Amount of a stdio server's stderr kept for error messages
+	mcpStderrLimit = 4096
+
+	// How long a stdio server is given to exit after its input is closed
+	mcpShutdownTimeout = 2 * time.Second
+)
+
+// Redirects aren't followed, so a local server can't send requests elsewhere
+var mcpHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// A Model Context Protocol server whose tools are offered to the model by a task
+// Servers are started or connected to when the task runs, and closed when it finishes
+type MCPServer struct {
+	// Command that starts a server speaking over stdio, run from the workflow's directory
+	Command string   `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+	// Variables added to the command's environment
+	Env map[string]string `yaml:"env,omitempty"`
+	// Endpoint of a local server using the streamable HTTP transport, e.g. http://localhost:3000/mcp
+	URL string `yaml:"url,omitempty"`
+}
+
+func (s MCPServer) String() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return strings.Join(append([]string{s.Command}, s.Args...), " ")
+}
+
+// Checks the server is either a command or a local url
+func (s MCPServer) validate() error {
+	switch {
+	case s.Command != "" && s.URL != "":
+		return errors.New("only one of command or url can be set")
+	case s.Command == "" && s.URL == "":
+		return errors.New("command or url is required")
+	case s.URL != "":
+		return checkLocalURL(s.URL)
+	}
+	return nil
+}
+
+// Checks a url is http or https on the local machine
+func checkLocalURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url must be http or https: %s", raw)
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("url must be a local address: %s", raw)
+	}
+	return nil
+}
+
+type mcpRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int        `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type mcpResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type mcpToolDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+type mcpToolResult struct {
+	Content []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Resource *struct {
+			Text string `json:"text"`
+		} `json:"resource"`
+	} `json:"content"`
+	IsError bool `json:"isError"`
+}
+
+// A connection to an MCP server, used by one task at a time
+type mcpSession struct {
+	server MCPServer
+	nextID int
+
+	// Set for servers started with a command
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *tailBuffer
+
+	// Set by servers using the streamable HTTP transport
+	sessionID       string
+	protocolVersion string
+}
+
+// Starts or connects to the server and completes the initialization handshake
+// Commands are run from dir and stopped when the context is done
+func connectMCPServer(ctx context.Context, server MCPServer, dir string) (*mcpSession, error) {
+	if err := server.validate(); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %v", server, err)
+	}
+
+	s := &mcpSession{server: server}
+	if server.Command != "" {
+		if err := s.start(ctx, dir); err != nil {
+			return nil, fmt.Errorf("mcp server %s: %v", server, err)
+		}
+	}
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err := s.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "assembllm", "version": "1.0.0"},
+	}, &result)
+	if err == nil {
+		s.protocolVersion = result.ProtocolVersion
+		err = s.notify(ctx, "notifications/initialized")
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *mcpSession) start(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, s.server.Command, s.server.Args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	for k, v := range s.server.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	s.stderr = &tailBuffer{}
+	cmd.Stderr = s.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.cmd, s.stdin, s.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// Stops the server, or ends the HTTP session
+func (s *mcpSession) close() {
+	if s.cmd != nil {
+		s.stdin.Close()
+		done := make(chan struct{})
+		go func() {
+			s.cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(mcpShutdownTimeout):
+			s.cmd.Process.Kill()
+			<-done
+		}
+		return
+	}
+
+	if s.sessionID != "" {
+		req, err := http.NewRequest(http.MethodDelete, s.server.URL, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Mcp-Session-Id", s.sessionID)
+		if res, err := mcpHTTPClient.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+// Sends a request and decodes its result into result
+func (s *mcpSession) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	s.nextID++
+	id := s.nextID
+
+	res, err := s.roundTrip(ctx, mcpRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("mcp server %s: %s: %v", s.server, method, err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("mcp server %s: %s: %s", s.server, method, res.Error.Message)
+	}
+	if result != nil {
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			return fmt.Errorf("mcp server %s: %s: invalid result: %v", s.server, method, err)
+		}
+	}
+	return nil
+}
+
+func (s *mcpSession) notify(ctx context.Context, method string) error {
+	if _, err := s.roundTrip(ctx, mcpRequest{JSONRPC: "2.0", Method: method}); err != nil {
+		return fmt.Errorf("mcp server %s: %s: %v", s.server, method, err)
+	}
+	return nil
+}
+
+// Sends a message, returning the response to it, or nothing for a notification
+func (s *mcpSession) roundTrip(ctx context.Context, req mcpRequest) (*mcpResponse, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cmd != nil {
+		return s.roundTripStdio(ctx, data, req.ID)
+	}
+	return s.roundTripHTTP(ctx, data, req.ID)
+}
+
+func (s *mcpSession) roundTripStdio(ctx context.Context, data []byte, id *int) (*mcpResponse, error) {
+	// Reads block until the server writes, so the server is stopped when the context is done
+	stop := context.AfterFunc(ctx, func() { s.cmd.Process.Kill() })
+	defer stop()
+
+	if _, err := s.stdin.Write(append(data, '\n')); err != nil {
+		return nil, s.exitError(ctx, err)
+	}
+	if id == nil {
+		return nil, nil
+	}
+
+	for {
+		line, err := s.stdout.ReadBytes('\n')
+		if err != nil {
+			return nil, s.exitError(ctx, err)
+		}
+
+		res, ok := s.match(line, *id)
+		if ok {
+			return res, nil
+		}
+	}
+}
+
+// Decodes a message from the server, checking whether it's the response to the request with id
+// Requests from the server are answered as unsupported, since the client offers no capabilities
+func (s *mcpSession) match(data []byte, id int) (*mcpResponse, bool) {
+	var res mcpResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, false
+	}
+
+	if res.Method != "" {
+		if res.ID != nil && s.cmd != nil {
+			reply, _ := json.Marshal(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      res.ID,
+				"error":   map[string]interface{}{"code": -32601, "message": "method not found: " + res.Method},
+			})
+			s.stdin.Write(append(reply, '\n'))
+		}
+		return nil, false
+	}
+
+	return &res, string(res.ID) == strconv.Itoa(id)
+}
+
+// Describes why a stdio server stopped responding, with the end of its stderr
+func (s *mcpSession) exitError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("server exited")
+	}
+	if stderr := strings.TrimSpace(s.stderr.String()); stderr != "" {
+		return fmt.Errorf("%v: %s", err, stderr)
+	}
+	return err
+}
+
+func (s *mcpSession) roundTripHTTP(ctx context.Context, data []byte, id *int) (*mcpResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.server.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if s.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", s.sessionID)
+	}
+	if s.protocolVersion != "" {
+		req.Header.Set("Mcp-Protocol-Version", s.protocolVersion)
+	}
+
+	res, err := mcpHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if sessionID := res.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		s.sessionID = sessionID
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	if id == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		return s.readEvents(res.Body, *id)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response, ok := s.match(body, *id); ok {
+		return response, nil
+	}
+	return nil, errors.New("no response in reply")
+}
+
+// Reads server-sent events until the response to the request with id
+func (s *mcpSession) readEvents(r io.Reader, id int) (*mcpResponse, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || len(data) == 0 {
+			continue
+		}
+
+		if res, ok := s.match([]byte(strings.Join(data, "\n")), id); ok {
+			return res, nil
+		}
+		data = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if res, ok := s.match([]byte(strings.Join(data, "\n")), id); ok {
+		return res, nil
+	}
+	return nil, errors.New("event stream ended without a response")
+}
+
+// Gets the server's tools, with their input schemas as the server describes them
+func (s *mcpSession) tools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	params := map[string]interface{}{}
+	for {
+		var result struct {
+			Tools      []mcpToolDefinition `json:"tools"`
+			NextCursor string              `json:"nextCursor"`
+		}
+		if err := s.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		for _, t := range result.Tools {
+			tool := Tool{Name: t.Name, Description: t.Description, mcp: s}
+			// The schema is sent to plugins as is, decoding it is only a best effort for readers of Tool
+			_ = json.Unmarshal(t.InputSchema, &tool.InputSchema)
+			tool.InputSchema.raw = t.InputSchema
+			tools = append(tools, tool)
+		}
+
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		params["cursor"] = result.NextCursor
+	}
+}
+
+// Calls a tool, returning its text content
+// Errors reported by the tool are returned as output, so the model can see them and recover
+func (s *mcpSession) callTool(ctx context.Context, name string, args map[string]interface{}) (string, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+
+	var result mcpToolResult
+	if err := s.call(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, c := range result.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Resource != nil && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		default:
+			parts = append(parts, "["+c.Type+" content omitted]")
+		}
+	}
+
+	out := strings.Join(parts, "\n")
+	if result.IsError {
+		out = "Error: " + out
+	}
+	return out, nil
+}
+
+// Connects to a task's MCP servers and gets their tools
+// The returned function closes the servers, and is safe to call when an error is returned
+func (sc scriptContext) connectMCPServers(servers []MCPServer, existing []Tool) ([]Tool, func(), error) {
+	dir := ""
+	if sc.workflowPath != "" {
+		dir = filepath.Dir(sc.workflowPath)
+	}
+
+	var sessions []*mcpSession
+	closeAll := func() {
+		for _, s := range sessions {
+			s.close()
+		}
+	}
+
+	tools := append([]Tool{}, existing...)
+	for _, server := range servers {
+		s, err := connectMCPServer(sc.context(), server, dir)
+		if err != nil {
+			return nil, closeAll, err
+		}
+		sessions = append(sessions, s)
+
+		serverTools, err := s.tools(sc.context())
+		if err != nil {
+			return nil, closeAll, err
+		}
+		for _, tool := range serverTools {
+			if _, ok := findTool(tools, tool.Name); ok {
+				return nil, closeAll, fmt.Errorf("mcp server %s: tool %s conflicts with another tool of the task", server, tool.Name)
+			}
+			tools = append(tools, tool)
+		}
+	}
+
+	return tools, closeAll, nil
+}
+
+// Keeps the end of what's written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > mcpStderrLimit {
+		b.buf = b.buf[len(b.buf)-mcpStderrLimit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
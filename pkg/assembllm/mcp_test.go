@@ -0,0 +1,185 @@
+package assembllm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Answers a request the way a server with a single echo tool would
+func fakeMCPResult(method string, params json.RawMessage) interface{} {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{"protocolVersion": mcpProtocolVersion, "capabilities": map[string]interface{}{}}
+	case "tools/list":
+		return map[string]interface{}{"tools": []interface{}{
+			map[string]interface{}{
+				"name":        "echo",
+				"description": "Echoes the text",
+				"inputSchema": map[string]interface{}{
+					"type":                 "object",
+					"properties":           map[string]interface{}{"text": map[string]interface{}{"type": "string", "minLength": 1}},
+					"required":             []string{"text"},
+					"additionalProperties": false,
+				},
+			},
+		}}
+	case "tools/call":
+		var p struct {
+			Arguments struct {
+				Text string `json:"text"`
+			} `json:"arguments"`
+		}
+		json.Unmarshal(params, &p)
+		if p.Arguments.Text == "" {
+			return map[string]interface{}{"content": []interface{}{map[string]string{"type": "text", "text": "text is required"}}, "isError": true}
+		}
+		return map[string]interface{}{"content": []interface{}{map[string]string{"type": "text", "text": p.Arguments.Text}}}
+	}
+	return nil
+}
+
+type fakeMCPMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Serves the fake server over stdio when the test binary is run as a server
+func TestMain(m *testing.M) {
+	if os.Getenv("ASSEMBLLM_FAKE_MCP_SERVER") != "1" {
+		os.Exit(m.Run())
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg fakeMCPMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID == nil {
+			continue
+		}
+		// Log lines on stdout are skipped by the client
+		fmt.Println("handling " + msg.Method)
+		data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": fakeMCPResult(msg.Method, msg.Params)})
+		fmt.Println(string(data))
+	}
+	os.Exit(0)
+}
+
+func testMCPSession(t *testing.T, server MCPServer) {
+	ctx := context.Background()
+	s, err := connectMCPServer(ctx, server, "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer s.close()
+
+	tools, err := s.tools(ctx)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(tools) != 1 || tools[0].Name != "echo" || !tools[0].hasImplementation() {
+		t.Fatalf("want the echo tool, got %v", tools)
+	}
+
+	schema, _ := json.Marshal(tools[0].InputSchema)
+	if !strings.Contains(string(schema), `"minLength":1`) || tools[0].InputSchema.Required[0] != "text" {
+		t.Fatalf("want the server's schema, got %s", schema)
+	}
+
+	tests := []struct {
+		input map[string]interface{}
+		want  string
+	}{
+		{map[string]interface{}{"text": "hello"}, "hello"},
+		{nil, "Error: text is required"},
+	}
+	for _, tt := range tests {
+		got, err := scriptContext{}.runTool(tools[0], ToolCall{Name: "echo", Input: tt.input})
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if got != tt.want {
+			t.Fatalf("want %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestMCPStdioServer(t *testing.T) {
+	t.Parallel()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testMCPSession(t, MCPServer{Command: exe, Env: map[string]string{"ASSEMBLLM_FAKE_MCP_SERVER": "1"}})
+}
+
+func TestMCPHTTPServer(t *testing.T) {
+	t.Parallel()
+
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = r.Header.Get("Mcp-Session-Id") == "s1"
+			return
+		}
+
+		var msg fakeMCPMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		if msg.Method != "initialize" && r.Header.Get("Mcp-Session-Id") != "s1" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", "s1")
+		if msg.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": fakeMCPResult(msg.Method, msg.Params)})
+		if msg.Method == "tools/call" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	testMCPSession(t, MCPServer{URL: srv.URL})
+	if !deleted {
+		t.Fatalf("want the session ended on close")
+	}
+}
+
+func TestMCPServerValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		server MCPServer
+		ok     bool
+	}{
+		{MCPServer{Command: "npx", Args: []string{"server"}}, true},
+		{MCPServer{URL: "http://localhost:3000/mcp"}, true},
+		{MCPServer{URL: "http://127.0.0.1:3000/mcp"}, true},
+		{MCPServer{URL: "http://[::1]:3000/mcp"}, true},
+		{MCPServer{URL: "https://example.com/mcp"}, false},
+		{MCPServer{URL: "file:///tmp/mcp"}, false},
+		{MCPServer{Command: "npx", URL: "http://localhost:3000/mcp"}, false},
+		{MCPServer{}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.server.validate(); (err == nil) != tt.ok {
+			t.Fatalf("%s: want ok %v, got %v", tt.server, tt.ok, err)
+		}
+	}
+}
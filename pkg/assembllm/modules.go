@@ -0,0 +1,306 @@
+package assembllm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero"
+)
+
+// How long a module downloaded from a source without a hash is used before it's downloaded again
+const unpinnedModuleTTL = 24 * time.Hour
+
+var (
+	// Compilation caches shared by every plugin runtime, keyed by their directory with "" kept in memory
+	compilationCaches   = map[string]wazero.CompilationCache{}
+	compilationCachesMu sync.Mutex
+
+	// Modules downloaded during this process, keyed by url and hash
+	downloads sync.Map
+
+	// Plugin instances that finished a call and can be reused
+	idlePlugins = &pluginPool{idle: map[string][]*CompletionsPlugin{}}
+)
+
+// Gets the runtime config for plugins, compiling modules once per process, and once per module when a module directory is set
+func (p CompletionPluginConfig) runtimeConfig() wazero.RuntimeConfig {
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+
+	compilationCachesMu.Lock()
+	defer compilationCachesMu.Unlock()
+
+	cache, ok := compilationCaches[p.ModuleDir]
+	if !ok {
+		cache = wazero.NewCompilationCache()
+		if p.ModuleDir != "" {
+			dirCache, err := wazero.NewCompilationCacheWithDir(filepath.Join(p.ModuleDir, "compiled"))
+			if err != nil {
+				p.logger().Printf("%s: compiled modules won't be kept between runs: %v", p.Name, err)
+			} else {
+				cache = dirCache
+			}
+		}
+		compilationCaches[p.ModuleDir] = cache
+	}
+
+	return config.WithCompilationCache(cache)
+}
+
+// Gets the wasm for the plugin's source, downloading remote sources once
+func (p CompletionPluginConfig) wasm(ctx context.Context) (extism.Wasm, error) {
+	if strings.HasPrefix(p.Source, "https://") {
+		data, err := p.download(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return extism.WasmData{Data: data, Hash: p.Hash}, nil
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	source := strings.Replace(p.Source, "~", homeDir, 1)
+
+	if !isFilePath(source) {
+		return nil, fmt.Errorf("file not found: %s", source)
+	}
+
+	return extism.WasmFile{Path: source, Hash: p.Hash}, nil
+}
+
+// Downloads the plugin's module, reusing modules downloaded earlier in the process
+// Downloaded modules are stored in the module directory by hash. Modules with a pinned hash are only
+// downloaded once, and those without one are downloaded again once they're older than unpinnedModuleTTL
+func (p CompletionPluginConfig) download(ctx context.Context) ([]byte, error) {
+	key := p.Source + "#" + p.Hash
+	if data, ok := downloads.Load(key); ok {
+		return data.([]byte), nil
+	}
+
+	var stale []byte
+	if p.Hash != "" {
+		if data, ok := p.storedModule(p.Hash); ok {
+			downloads.Store(key, data)
+			return data, nil
+		}
+	} else if data, fresh, ok := p.unpinnedModule(); ok {
+		if fresh {
+			downloads.Store(key, data)
+			return data, nil
+		}
+		stale = data
+	}
+
+	wasm, err := extism.WasmUrl{Url: p.Source, Hash: p.Hash}.ToWasmData(ctx)
+	if err != nil {
+		if stale == nil {
+			return nil, err
+		}
+		p.logger().Printf("%s: using the module downloaded earlier, unable to download it again: %v", p.Name, err)
+		downloads.Store(key, stale)
+		return stale, nil
+	}
+	if p.Hash != "" && ModuleHash(wasm.Data) != p.Hash {
+		return nil, fmt.Errorf("hash mismatch for %s", p.Source)
+	}
+
+	if p.Hash != "" {
+		err = p.storeModule(wasm.Data)
+	} else {
+		err = p.storeUnpinnedModule(wasm.Data)
+	}
+	if err != nil {
+		p.logger().Printf("%s: failed to store downloaded module: %v", p.Name, err)
+	}
+	downloads.Store(key, wasm.Data)
+
+	return wasm.Data, nil
+}
+
+// Gets the path a downloaded module with the hash is stored at
+func (p CompletionPluginConfig) storedModulePath(hash string) (string, bool) {
+	if p.ModuleDir == "" || hash == "" || strings.ContainsAny(hash, `/\.`) {
+		return "", false
+	}
+	return filepath.Join(p.ModuleDir, "downloads", hash+".wasm"), true
+}
+
+// Gets a stored module, ignoring it when it no longer matches its hash
+func (p CompletionPluginConfig) storedModule(hash string) ([]byte, bool) {
+	path, ok := p.storedModulePath(hash)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || ModuleHash(data) != hash {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Stores a downloaded module by its hash
+func (p CompletionPluginConfig) storeModule(data []byte) error {
+	path, ok := p.storedModulePath(ModuleHash(data))
+	if !ok {
+		return nil
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// Which module was last downloaded from a source without a hash
+type sourceRecord struct {
+	Source     string    `json:"source"`
+	Hash       string    `json:"hash"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
+// Gets the path of the record for the plugin's source, named by the hash of its url
+func (p CompletionPluginConfig) sourceRecordPath() (string, bool) {
+	if p.ModuleDir == "" {
+		return "", false
+	}
+	return filepath.Join(p.ModuleDir, "downloads", "sources", ModuleHash([]byte(p.Source))+".json"), true
+}
+
+// Gets the module last downloaded from the plugin's source, and whether it's recent enough to use without downloading it again
+func (p CompletionPluginConfig) unpinnedModule() ([]byte, bool, bool) {
+	path, ok := p.sourceRecordPath()
+	if !ok {
+		return nil, false, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, false
+	}
+	var record sourceRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.Source != p.Source {
+		return nil, false, false
+	}
+
+	module, ok := p.storedModule(record.Hash)
+	if !ok {
+		return nil, false, false
+	}
+
+	return module, time.Since(record.Downloaded) < unpinnedModuleTTL, true
+}
+
+// Stores a module downloaded from a source without a hash, recording it as the source's latest module
+func (p CompletionPluginConfig) storeUnpinnedModule(data []byte) error {
+	path, ok := p.sourceRecordPath()
+	if !ok {
+		return nil
+	}
+
+	if err := p.storeModule(data); err != nil {
+		return err
+	}
+
+	record, err := json.Marshal(sourceRecord{Source: p.Source, Hash: ModuleHash(data), Downloaded: time.Now()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, record)
+}
+
+// Writes a file by renaming a temporary file over it, so readers never see a partial write
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Gets the sha256 hash of a module, as pinned in plugin configurations
+func ModuleHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Plugin instances kept between calls, keyed by the module they were created from
+type pluginPool struct {
+	mu   sync.Mutex
+	idle map[string][]*CompletionsPlugin
+}
+
+func (pool *pluginPool) take(key string) *CompletionsPlugin {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	plugins := pool.idle[key]
+	if len(plugins) == 0 {
+		return nil
+	}
+	plugin := plugins[len(plugins)-1]
+	pool.idle[key] = plugins[:len(plugins)-1]
+
+	return plugin
+}
+
+func (pool *pluginPool) put(key string, plugin *CompletionsPlugin) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.idle[key] = append(pool.idle[key], plugin)
+}
+
+func (p CompletionPluginConfig) poolKey() string {
+	return fmt.Sprintf("%s#%s#%t#%s", p.Source, p.Hash, p.Wasi, p.ModuleDir)
+}
+
+// Gets an idle instance of the plugin's module configured for this plugin, or creates one
+// The configuration's secrets must already be resolved
+// Instances are used by one call at a time and returned with releasePlugin
+func (p CompletionPluginConfig) acquirePlugin(ctx context.Context) (*CompletionsPlugin, error) {
+	if plugin := idlePlugins.take(p.poolKey()); plugin != nil {
+		p.configure(plugin)
+		return plugin, nil
+	}
+
+	// Chunks are sent to the handler in the call's context, so the instance isn't tied to this call
+	base := p
+	base.OnChunk = nil
+	plugin, err := base.createPlugin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plugin, nil
+}
+
+// Returns the instance for reuse after a successful call, otherwise closes it
+// since a failed or cancelled call can leave the module unusable
+func (p CompletionPluginConfig) releasePlugin(plugin *CompletionsPlugin, reuse bool) {
+	if !reuse {
+		plugin.Plugin.Close()
+		return
+	}
+
+	idlePlugins.put(p.poolKey(), plugin)
+}
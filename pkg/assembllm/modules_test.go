@@ -0,0 +1,128 @@
+package assembllm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadUsesStoredModule(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("\x00asm\x01\x00\x00\x00")
+	pluginCfg := CompletionPluginConfig{
+		Name:      "stored",
+		Source:    "https://example.invalid/stored.wasm",
+		Hash:      ModuleHash(data),
+		ModuleDir: t.TempDir(),
+	}
+
+	if err := pluginCfg.storeModule(data); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	got, err := pluginCfg.download(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if string(got) != string(data) {
+		t.Fatalf("want the stored module, got %q", got)
+	}
+
+	// A stored module that doesn't match its hash is downloaded again
+	path, _ := pluginCfg.storedModulePath(pluginCfg.Hash)
+	if err := os.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pluginCfg.storedModule(pluginCfg.Hash); ok {
+		t.Fatalf("expected a modified module to be ignored")
+	}
+}
+
+func TestDownloadUsesStoredUnpinnedModule(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("\x00asm\x01\x00\x00\x00")
+	pluginCfg := CompletionPluginConfig{
+		Name:      "unpinned",
+		Source:    "https://example.invalid/unpinned.wasm",
+		ModuleDir: t.TempDir(),
+	}
+
+	if _, _, ok := pluginCfg.unpinnedModule(); ok {
+		t.Fatalf("expected no stored module before one is downloaded")
+	}
+	if err := pluginCfg.storeUnpinnedModule(data); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// The module is stored by its hash, and used until it's due to be downloaded again
+	if _, ok := pluginCfg.storedModule(ModuleHash(data)); !ok {
+		t.Fatalf("expected the module to be stored by its hash")
+	}
+	got, fresh, ok := pluginCfg.unpinnedModule()
+	if !ok || !fresh || string(got) != string(data) {
+		t.Fatalf("want the fresh stored module, got %q, %v, %v", got, fresh, ok)
+	}
+
+	other := pluginCfg
+	other.Source = "https://example.invalid/other.wasm"
+	if _, _, ok := other.unpinnedModule(); ok {
+		t.Fatalf("expected modules to be stored per source")
+	}
+
+	// A stale module is downloaded again, and still used when the download fails
+	path, _ := pluginCfg.sourceRecordPath()
+	record, err := json.Marshal(sourceRecord{Source: pluginCfg.Source, Hash: ModuleHash(data), Downloaded: time.Now().Add(-2 * unpinnedModuleTTL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, record, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, fresh, ok := pluginCfg.unpinnedModule(); !ok || fresh {
+		t.Fatalf("want a stale stored module, got %v, %v", fresh, ok)
+	}
+
+	var logs bytes.Buffer
+	pluginCfg.Logger = log.New(&logs, "", 0)
+	got, err = pluginCfg.download(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if string(got) != string(data) {
+		t.Fatalf("want the stored module, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "using the module downloaded earlier") {
+		t.Fatalf("expected the failed download to be logged, got %q", logs.String())
+	}
+}
+
+func TestPluginPool(t *testing.T) {
+	t.Parallel()
+
+	pool := &pluginPool{idle: map[string][]*CompletionsPlugin{}}
+	a, b := &CompletionsPlugin{}, &CompletionsPlugin{}
+
+	if pool.take("a") != nil {
+		t.Fatalf("expected an empty pool")
+	}
+
+	pool.put("a", a)
+	pool.put("b", b)
+
+	if got := pool.take("a"); got != a {
+		t.Fatalf("want the idle instance for a, got %v", got)
+	}
+	if pool.take("a") != nil {
+		t.Fatalf("expected an instance to be used by one call at a time")
+	}
+	if got := pool.take("b"); got != b {
+		t.Fatalf("want the idle instance for b, got %v", got)
+	}
+}
@@ -0,0 +1,157 @@
+package assembllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// Reported when a plugin call, task, or workflow runs past its timeout
+var ErrTimeout = errors.New("timed out")
+
+// Controls how failed plugin calls are retried, set on a plugin and overridden per task
+type RetryPolicy struct {
+	// Number of times to retry a failed call
+	Retries int `yaml:"retries,omitempty"`
+	// Delay before the first retry, doubled on each attempt, e.g. 500ms or 2s
+	Backoff string `yaml:"backoff,omitempty"`
+	// Regular expressions matched against the error, all errors are retried when empty
+	RetryOn []string `yaml:"retry_on,omitempty"`
+}
+
+// Returns the policy with the override's values replacing those it sets
+func (r RetryPolicy) merge(override RetryPolicy) RetryPolicy {
+	if override.Retries != 0 {
+		r.Retries = override.Retries
+	}
+	if override.Backoff != "" {
+		r.Backoff = override.Backoff
+	}
+	if override.RetryOn != nil {
+		r.RetryOn = override.RetryOn
+	}
+	return r
+}
+
+// Checks the policy's backoff and patterns are valid
+func (r RetryPolicy) validate() error {
+	if r.Backoff != "" {
+		if _, err := time.ParseDuration(r.Backoff); err != nil {
+			return fmt.Errorf("invalid backoff: %v", err)
+		}
+	}
+	for _, pattern := range r.RetryOn {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid retry_on pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r RetryPolicy) initialBackoff() time.Duration {
+	if d, err := time.ParseDuration(r.Backoff); err == nil && d > 0 {
+		return d
+	}
+	return defaultBackoff
+}
+
+// Check if the error matches the policy's retry_on patterns
+func (r RetryPolicy) shouldRetry(err error) bool {
+	if len(r.RetryOn) == 0 {
+		return true
+	}
+	for _, pattern := range r.RetryOn {
+		if matched, _ := regexp.MatchString(pattern, err.Error()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates the plugin and makes the call, retrying failures according to the retry policy
+// Calls that have already streamed chunks are not retried, and retrying stops when the context is done
+func (p CompletionPluginConfig) callWithRetries(ctx context.Context, call func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error)) (string, error) {
+	if err := p.RetryPolicy.validate(); err != nil {
+		return "", fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+
+	// Secrets that can't be resolved are a configuration problem that retrying won't fix
+	p, err := p.withSecrets(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize plugin: %w", err)
+	}
+
+	streamed := p.collectChunks()
+	delay := p.RetryPolicy.initialBackoff()
+
+	for attempt := 1; ; attempt++ {
+		res, err := p.callOnce(ctx, streamed, call)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt > p.Retries || streamed.sb.Len() > 0 || ctx.Err() != nil || !p.RetryPolicy.shouldRetry(err) {
+			return "", err
+		}
+
+		p.logger().Printf("%s: attempt %d of %d failed, retrying in %s: %v", p.Name, attempt, p.Retries+1, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", err
+		}
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+// Makes a single call, limited to the plugin's timeout
+func (p CompletionPluginConfig) callOnce(ctx context.Context, streamed *chunkCollector, call func(ctx context.Context, plugin *CompletionsPlugin) ([]byte, error)) (string, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	plugin, err := p.acquirePlugin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize plugin: %w", contextError(ctx, err))
+	}
+
+	out, err := call(withChunkHandler(ctx, p.OnChunk), plugin)
+	p.releasePlugin(plugin, err == nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get completion: %w", contextError(ctx, err))
+	}
+
+	res, env := unwrapEnvelope(streamed.response(out))
+	p.reportUsage(env)
+
+	return res, nil
+}
+
+// Reports why the context ended instead of the error it caused, which is often an opaque module closed error
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return ctxErr
+	}
+	return err
+}
+
+func (p CompletionPluginConfig) logger() *log.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return log.Default()
+}
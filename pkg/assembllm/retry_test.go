@@ -0,0 +1,52 @@
+package assembllm
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRetryFailedCalls(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy   RetryPolicy
+		attempts int
+	}{
+		{RetryPolicy{}, 0},
+		{RetryPolicy{Retries: 2, Backoff: "1ms"}, 2},
+		{RetryPolicy{Retries: 2, Backoff: "1ms", RetryOn: []string{"file not found"}}, 2},
+		{RetryPolicy{Retries: 2, Backoff: "1ms", RetryOn: []string{"429", "rate limit"}}, 0},
+	}
+
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		pluginCfg := CompletionPluginConfig{
+			Name:        "missing",
+			Source:      "does-not-exist.wasm",
+			RetryPolicy: tt.policy,
+			Logger:      log.New(&logs, "", 0),
+		}
+
+		_, err := pluginCfg.GenerateResponse("hello")
+		if err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+
+		if got := strings.Count(logs.String(), "retrying"); got != tt.attempts {
+			t.Fatalf("%+v: want %d retries, got %d", tt.policy, tt.attempts, got)
+		}
+	}
+}
+
+func TestMergeRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	plugin := RetryPolicy{Retries: 3, Backoff: "2s", RetryOn: []string{"429"}}
+	got := plugin.merge(RetryPolicy{Retries: 1})
+
+	if got.Retries != 1 || got.Backoff != "2s" || len(got.RetryOn) != 1 {
+		t.Fatalf("want task retries with plugin backoff and patterns, got %+v", got)
+	}
+}
@@ -0,0 +1,239 @@
+package assembllm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bitfield/script"
+	"github.com/expr-lang/expr"
+	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+func resend(ctx context.Context, to string, from string, subject string, body string) error {
+	var html bytes.Buffer
+
+	gm := goldmark.New(
+		goldmark.WithExtensions(
+			extension.Linkify,
+			extension.Strikethrough,
+			extension.Table,
+		),
+	)
+	_ = gm.Convert([]byte(body), &html)
+
+	escapedHTML := strconv.QuoteToASCII(html.String())
+	escapedHTML = escapedHTML[1 : len(escapedHTML)-1] // Remove the extra double quotes
+
+	payload := []byte(fmt.Sprintf(`{
+	        "from": "%s",
+	        "to": "%s",
+	        "subject": "%s",
+	        "html": "%s"
+	    }`, from, to, subject, escapedHTML))
+
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.resend.com/emails", bytes.NewBuffer(payload))
+
+	if err != nil {
+		return err
+	}
+
+	apiKey := os.Getenv("RESEND_API_KEY")
+	if apiKey == "" {
+		return errors.New("RESEND_API_KEY is not set")
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error sending email \n status code: %d\n%s", res.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+func httpGet(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+func appendFile(content string, path string) (int64, error) {
+	b, err := script.Echo(content).AppendFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return b, nil
+}
+
+func readfile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func callExtismPlugin(ctx context.Context, source string, function string, input string) (string, error) {
+	var wasm extism.Wasm
+
+	if strings.HasPrefix(source, "https://") {
+		wasm = extism.WasmUrl{
+			Url: source,
+		}
+	} else {
+		if !isFilePath(source) {
+			return "", fmt.Errorf("file not found: %s", source)
+		}
+
+		wasm = extism.WasmFile{
+			Path: source,
+		}
+	}
+
+	manifest := extism.Manifest{
+		Wasm: []extism.Wasm{
+			wasm,
+		},
+	}
+
+	plugin, err := extism.NewPlugin(
+		ctx,
+		manifest,
+		extism.PluginConfig{
+			EnableWasi:    true,
+			RuntimeConfig: wazero.NewRuntimeConfig().WithCloseOnContextDone(true),
+		},
+		[]extism.HostFunction{},
+	)
+	if err != nil {
+		return "", err
+	}
+	if plugin == nil {
+		return "", fmt.Errorf("plugin is nil")
+	}
+
+	_, out, err := plugin.CallWithContext(ctx, function, []byte(input))
+	if err != nil {
+		return "", contextError(ctx, err)
+
+	}
+	response := string(out)
+
+	return response, nil
+}
+
+// Holds the per-run state made available to workflow scripts
+type scriptContext struct {
+	// Cancels the plugin calls, requests, and workflows started by the run
+	ctx          context.Context
+	client       *Client
+	workflowPath string
+	iterValue    interface{}
+	vars         map[string]interface{}
+	// The workflow's input, available to templates as .input
+	input string
+	// Values of the workflow's inputs, keyed by input name
+	inputs map[string]interface{}
+	// Outputs of the upstream tasks, keyed by task name
+	outputs map[string]string
+	// Absolute paths of this workflow and the workflows that chained to it
+	callers []string
+}
+
+// Functions available to every workflow expression, network calls are cancelled when the context is done
+func scriptFunctions(ctx context.Context, input string) map[string]interface{} {
+	return map[string]interface{}{
+		"input": input,
+		"Get": func(url string) (string, error) {
+			return httpGet(ctx, url)
+		},
+		"AppendFile": appendFile,
+		"ReadFile":   readfile,
+		"Extism": func(source string, function string, input string) (string, error) {
+			return callExtismPlugin(ctx, source, function, input)
+		},
+		"Resend": func(to string, from string, subject string, body string) error {
+			return resend(ctx, to, from, subject, body)
+		},
+	}
+}
+
+// The context of the run, scripts outside of a run are not cancelled
+func (sc scriptContext) context() context.Context {
+	if sc.ctx == nil {
+		return context.Background()
+	}
+	return sc.ctx
+}
+
+func (sc scriptContext) runExpr(input string, expression string) (string, error) {
+	return sc.runExprWithEnv(input, expression, nil)
+}
+
+// The environment for task scripts, with additional variables added to it
+func (sc scriptContext) env(input string, vars map[string]interface{}) map[string]interface{} {
+	env := scriptFunctions(sc.context(), input)
+	env["iterValue"] = sc.iterValue
+	env["Workflow"] = sc.workflowChain
+	env["outputs"] = sc.outputs
+	env["vars"] = sc.vars
+	env["inputs"] = sc.inputs
+	for k, v := range vars {
+		env[k] = v
+	}
+	return env
+}
+
+// Runs the expression with additional variables added to the environment
+func (sc scriptContext) runExprWithEnv(input string, expression string, vars map[string]interface{}) (string, error) {
+	env := sc.env(input, vars)
+
+	program, err := expr.Compile(expression, expr.Env(env))
+	if err != nil {
+		return "", err
+	}
+
+	output, err := expr.Run(program, env)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v", output), nil
+}
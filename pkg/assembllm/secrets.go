@@ -0,0 +1,112 @@
+package assembllm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	// Secrets resolved during this process, keyed by their reference so commands only run once
+	resolvedSecrets sync.Map
+
+	envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Returns a copy of the configuration with its apiKey and accountId references resolved to their values
+func (p CompletionPluginConfig) withSecrets(ctx context.Context) (CompletionPluginConfig, error) {
+	var err error
+	if p.APIKey, err = p.resolveSecret(ctx, "apiKey", p.APIKey); err != nil {
+		return CompletionPluginConfig{}, err
+	}
+	if p.AccountId, err = p.resolveSecret(ctx, "accountId", p.AccountId); err != nil {
+		return CompletionPluginConfig{}, err
+	}
+	return p, nil
+}
+
+// Returns a copy of the configuration with the secrets that resolve, leaving the others empty
+func (p CompletionPluginConfig) withAvailableSecrets(ctx context.Context) CompletionPluginConfig {
+	p.APIKey, _ = p.resolveSecret(ctx, "apiKey", p.APIKey)
+	p.AccountId, _ = p.resolveSecret(ctx, "accountId", p.AccountId)
+	return p
+}
+
+// Resolves a secret reference, either env:NAME, file:/path, cmd:command, or a literal value
+// References without a prefix that are environment variable names are read from the environment
+func (p CompletionPluginConfig) resolveSecret(ctx context.Context, field string, ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	if value, ok := resolvedSecrets.Load(ref); ok {
+		return value.(string), nil
+	}
+
+	value, err := p.lookupSecret(ctx, field, ref)
+	if err != nil {
+		return "", fmt.Errorf("plugin %s: %s: %v", p.Name, field, err)
+	}
+
+	resolvedSecrets.Store(ref, value)
+	return value, nil
+}
+
+func (p CompletionPluginConfig) lookupSecret(ctx context.Context, field string, ref string) (string, error) {
+	kind, value, _ := strings.Cut(ref, ":")
+
+	switch {
+	case kind == "env":
+		return lookupEnv(value)
+	case kind == "file":
+		homeDir, _ := os.UserHomeDir()
+		data, err := os.ReadFile(strings.Replace(value, "~", homeDir, 1))
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret file: %v", err)
+		}
+		return nonEmptySecret(string(data), "file "+value)
+	case kind == "cmd":
+		return runSecretCommand(ctx, value)
+	case envNameRegex.MatchString(ref):
+		return lookupEnv(ref)
+	default:
+		p.logger().Printf("%s: %s is set to a literal value, use env:, file:, or cmd: to keep it out of the config file", p.Name, field)
+		return ref, nil
+	}
+}
+
+func lookupEnv(name string) (string, error) {
+	return nonEmptySecret(os.Getenv(name), "environment variable "+name)
+}
+
+// Gets the trimmed output of a command, such as pass show openai
+func runSecretCommand(ctx context.Context, command string) (string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("command %q failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nonEmptySecret(string(out), "command "+command)
+}
+
+func nonEmptySecret(value string, source string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("%s is not set", source)
+	}
+	return value, nil
+}
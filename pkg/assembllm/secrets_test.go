@@ -0,0 +1,105 @@
+package assembllm
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("ASSEMBLLM_TEST_SECRET", "from-env")
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	pluginCfg := CompletionPluginConfig{Name: "openai", Logger: log.New(&logs, "", 0)}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"env:ASSEMBLLM_TEST_SECRET", "from-env"},
+		{"ASSEMBLLM_TEST_SECRET", "from-env"},
+		{"file:" + path, "from-file"},
+		{"cmd:echo from-cmd", "from-cmd"},
+		{"sk-literal-value", "sk-literal-value"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := pluginCfg.resolveSecret(context.Background(), "apiKey", tt.ref)
+		if err != nil {
+			t.Fatalf("%s: expected nil, got %v", tt.ref, err)
+		}
+		if got != tt.want {
+			t.Fatalf("want %s, got %s", tt.want, got)
+		}
+	}
+
+	if !strings.Contains(logs.String(), "literal value") {
+		t.Fatalf("expected a warning for the literal value, got %q", logs.String())
+	}
+}
+
+func TestMissingSecret(t *testing.T) {
+	t.Parallel()
+
+	pluginCfg := CompletionPluginConfig{Name: "anthropic", Source: "missing.wasm", APIKey: "env:ASSEMBLLM_TEST_MISSING"}
+
+	_, err := pluginCfg.GenerateResponse("hello")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	want := "plugin anthropic: apiKey: environment variable ASSEMBLLM_TEST_MISSING is not set"
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("want %s, got %v", want, err)
+	}
+}
+
+func TestMissingSecretIsNotRetried(t *testing.T) {
+	t.Parallel()
+
+	attempts := filepath.Join(t.TempDir(), "attempts")
+	pluginCfg := CompletionPluginConfig{
+		Name:        "anthropic",
+		Source:      "missing.wasm",
+		APIKey:      "cmd:echo attempt >> " + attempts + "; false",
+		RetryPolicy: RetryPolicy{Retries: 3, Backoff: "1ms"},
+	}
+
+	if _, err := pluginCfg.GenerateResponse("hello"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	data, err := os.ReadFile(attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "attempt"); got != 1 {
+		t.Fatalf("want the secret resolved once, got %d attempts", got)
+	}
+}
+
+func TestGetModelsWithoutSecrets(t *testing.T) {
+	t.Parallel()
+
+	source := filepath.Join(t.TempDir(), "echo.wasm")
+	if err := os.WriteFile(source, echoCompletionModule, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pluginCfg := CompletionPluginConfig{Name: "echo", Source: source, APIKey: "env:ASSEMBLLM_TEST_MISSING"}
+
+	// The plugin is called without the key, and its empty response isn't a list of models
+	_, err := pluginCfg.GetModels()
+	if err == nil || !strings.Contains(err.Error(), "failed to unmarshal models") {
+		t.Fatalf("want failed to unmarshal models, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package assembllm
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Functions available to prompt and role templates
+func (sc scriptContext) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"env": os.Getenv,
+		// Reads a file, relative paths resolve against the workflow's directory
+		"file": func(path string) (string, error) {
+			absPath, err := sc.getAbsolutePath(path)
+			if err != nil {
+				return "", err
+			}
+			data, err := os.ReadFile(absPath)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// Matches an action using the values or functions given to templates, e.g. {{ .input }} or {{ env "USER" }}
+var templateActionRegex = regexp.MustCompile(`{{-?\s*(\.|\$|env\b|file\b)`)
+
+// Reports whether text that doesn't parse as a template was meant as one, rather than holding literal
+// braces such as a Handlebars or Jinja snippet, so mistakes in templates are still reported
+func meantAsTemplate(text string) bool {
+	return templateActionRegex.MatchString(text)
+}
+
+func parseTemplate(name string, text string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// Expands a prompt or role template, e.g. {{ .previous }} or {{ .outputs.researcher }}
+// Also returns the names of the values the template refers to, so the caller can leave out
+// the input or previous output when the template places it
+func (sc scriptContext) expandTemplate(name string, text string, prev string) (string, map[string]bool, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil, nil
+	}
+
+	tmpl, err := parseTemplate(name, text, sc.templateFuncs())
+	if err != nil {
+		if !meantAsTemplate(text) {
+			return text, nil, nil
+		}
+		return "", nil, err
+	}
+
+	var iter interface{} = ""
+	if sc.iterValue != nil {
+		iter = sc.iterValue
+	}
+	data := map[string]interface{}{
+		"input":    sc.input,
+		"previous": prev,
+		"iter":     iter,
+		"outputs":  sc.outputs,
+		"inputs":   sc.inputs,
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", nil, err
+	}
+
+	refs := map[string]bool{}
+	templateRefs(tmpl.Tree.Root, refs)
+	return sb.String(), refs, nil
+}
+
+// Collects the names of the top-level values a template refers to, e.g. input for {{ .input }} or {{ $.input }}
+// References inside with and range blocks are included even though dot has changed, erring toward treating values as placed
+func templateRefs(node parse.Node, refs map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateRefs(child, refs)
+		}
+	case *parse.ActionNode:
+		templateRefs(n.Pipe, refs)
+	case *parse.IfNode:
+		templateRefs(&n.BranchNode, refs)
+	case *parse.RangeNode:
+		templateRefs(&n.BranchNode, refs)
+	case *parse.WithNode:
+		templateRefs(&n.BranchNode, refs)
+	case *parse.BranchNode:
+		templateRefs(n.Pipe, refs)
+		templateRefs(n.List, refs)
+		templateRefs(n.ElseList, refs)
+	case *parse.TemplateNode:
+		templateRefs(n.Pipe, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateRefs(cmd, refs)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateRefs(arg, refs)
+		}
+	case *parse.ChainNode:
+		templateRefs(n.Node, refs)
+	case *parse.FieldNode:
+		refs[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			refs[n.Ident[1]] = true
+		}
+	}
+}
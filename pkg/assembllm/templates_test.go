@@ -0,0 +1,99 @@
+package assembllm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.md"), []byte("the notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := scriptContext{
+		workflowPath: filepath.Join(dir, "workflow.yaml"),
+		input:        "the input",
+		iterValue:    "a",
+		outputs:      map[string]string{"researcher": "the research"},
+		inputs:       map[string]interface{}{"tone": "formal"},
+	}
+
+	tests := []struct {
+		text string
+		want string
+		refs []string
+	}{
+		{"no template", "no template", nil},
+		{"{{ .previous }} then {{ .input }}", "the previous then the input", []string{"previous", "input"}},
+		{"{{ .iter }} {{ .outputs.researcher }} {{ .inputs.tone }}", "a the research formal", []string{"iter", "outputs", "inputs"}},
+		{`{{ file "notes.md" }}`, "the notes", nil},
+		{"{{ with .outputs }}{{ .researcher }} {{ $.previous }}{{ end }}", "the research the previous", []string{"outputs", "previous"}},
+		{`Escape braces as {{"{{"}} .input }}`, "Escape braces as {{ .input }}", nil},
+		// Literal braces that don't parse as a template are left as written
+		{"Fill in {{#each items}}{{name}}{{/each}}", "Fill in {{#each items}}{{name}}{{/each}}", nil},
+		{"Render {{ user.name }} with Jinja", "Render {{ user.name }} with Jinja", nil},
+	}
+
+	for _, tt := range tests {
+		got, refs, err := sc.expandTemplate("prompt", tt.text, "the previous")
+		if err != nil {
+			t.Fatalf("%s: expected nil, got %v", tt.text, err)
+		}
+		if got != tt.want {
+			t.Fatalf("want %q, got %q", tt.want, got)
+		}
+		for _, ref := range tt.refs {
+			if !refs[ref] {
+				t.Fatalf("%s: want a reference to %s, got %v", tt.text, ref, refs)
+			}
+		}
+		if len(tt.refs) == 0 && refs["input"] {
+			t.Fatalf("%s: want no reference to input, got %v", tt.text, refs)
+		}
+	}
+
+	// Mistakes in templates are still errors
+	for _, text := range []string{"{{ .missing }}", "{{ .input }", `{{ env "HOME" | nope }}`} {
+		if _, _, err := sc.expandTemplate("prompt", text, ""); err == nil {
+			t.Fatalf("%s: expected error, got nil", text)
+		}
+	}
+}
+
+func TestTemplatePlacesInput(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(CompletionPluginConfigs{})
+
+	workflow := `
+tasks:
+  - name: placed
+    prompt: "Answer {{ .input }} briefly"
+    post_script: "'ok'"
+  - name: plain
+    prompt: "and {{ .input }} again"
+`
+
+	w, err := client.ParseWorkflow([]byte(workflow))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	results := w.StartIterations(context.Background(), "why?", []interface{}{nil}, 0)
+	if _, err := results[0].Wait(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	tasks := results[0].Tasks()
+	if want := "Answer why? briefly"; tasks[0].Prompt != want {
+		t.Fatalf("want %q, got %q", want, tasks[0].Prompt)
+	}
+	if want := "and why? again"; tasks[1].Prompt != want {
+		t.Fatalf("want %q, got %q", want, tasks[1].Prompt)
+	}
+}
@@ -0,0 +1,168 @@
+package assembllm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const defaultMaxToolIterations = 5
+
+// A wasm function used as a tool implementation
+type ExtismTool struct {
+	Source   string `yaml:"source"`
+	Function string `yaml:"function"`
+}
+
+// A tool call requested by the model
+type ToolCall struct {
+	ID    string                 `json:"id,omitempty"`
+	Name  string                 `json:"name"`
+	Input map[string]interface{} `json:"input"`
+}
+
+// The output of an executed tool call
+type ToolResult struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Output string `json:"output"`
+}
+
+// Check if the tool declares an implementation the engine can execute
+func (t Tool) hasImplementation() bool {
+	return t.Script != "" || t.Extism != nil || t.Workflow != "" || t.mcp != nil
+}
+
+// Check if any of the tools can be executed by the engine
+func hasImplementations(tools []Tool) bool {
+	for _, t := range tools {
+		if t.hasImplementation() {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the tool calls from a completionWithTools response
+// Returns false when the response is a final answer or calls a tool that can't be executed
+func parseToolCalls(response string, tools []Tool) ([]ToolCall, bool) {
+	calls, ok := decodeToolCalls(response)
+	if !ok {
+		return nil, false
+	}
+
+	for _, call := range calls {
+		tool, ok := findTool(tools, call.Name)
+		if !ok || !tool.hasImplementation() {
+			return nil, false
+		}
+	}
+
+	return calls, true
+}
+
+// Gets the tool calls in a completionWithTools response
+// Returns false when the response is a final answer
+func ParseToolCalls(response string) ([]ToolCall, bool) {
+	return decodeToolCalls(response)
+}
+
+// Decodes a response holding a tool call or an array of them
+// Returns false when the response is not a tool call
+func decodeToolCalls(response string) ([]ToolCall, bool) {
+	trimmed := strings.TrimSpace(response)
+
+	var calls []ToolCall
+	if err := json.Unmarshal([]byte(trimmed), &calls); err != nil {
+		var call ToolCall
+		if err := json.Unmarshal([]byte(trimmed), &call); err != nil {
+			return nil, false
+		}
+		calls = []ToolCall{call}
+	}
+
+	for _, call := range calls {
+		if call.Name == "" {
+			return nil, false
+		}
+	}
+
+	return calls, len(calls) > 0
+}
+
+func findTool(tools []Tool, name string) (Tool, bool) {
+	for _, t := range tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Tool{}, false
+}
+
+// Executes a tool call with the tool's implementation
+func (sc scriptContext) runTool(tool Tool, call ToolCall) (string, error) {
+	args, err := json.Marshal(call.Input)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case tool.Script != "":
+		return sc.runExprWithEnv(string(args), tool.Script, map[string]interface{}{"args": call.Input})
+	case tool.Extism != nil:
+		return callExtismPlugin(sc.context(), tool.Extism.Source, tool.Extism.Function, string(args))
+	case tool.Workflow != "":
+		return sc.workflowChain(tool.Workflow, string(args), call.Input)
+	case tool.mcp != nil:
+		return tool.mcp.callTool(sc.context(), call.Name, call.Input)
+	}
+
+	return "", fmt.Errorf("tool has no implementation: %s", tool.Name)
+}
+
+// Runs the prompt with tools, executing the model's tool calls and returning their results to it
+// until it provides a final answer, making at most maxIterations model calls
+// Returns the final answer and the tool calls that were executed
+func (sc scriptContext) generateResponseWithToolLoop(pluginCfg CompletionPluginConfig, prompt string, tools []Tool, maxIterations int) (string, []ToolCall, error) {
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxToolIterations
+	}
+
+	messages := []Message{{Role: "user", Content: prompt}}
+	var executed []ToolCall
+
+	for i := 0; i < maxIterations; i++ {
+		out, err := pluginCfg.GenerateResponseWithMessagesContext(sc.context(), messages, tools)
+		if err != nil {
+			return "", executed, err
+		}
+
+		calls, ok := parseToolCalls(out, tools)
+		if !ok {
+			return out, executed, nil
+		}
+
+		var results []ToolResult
+		for _, call := range calls {
+			tool, _ := findTool(tools, call.Name)
+			res, err := sc.runTool(tool, call)
+			if err != nil {
+				return "", executed, fmt.Errorf("error running tool %s: %w", call.Name, err)
+			}
+			executed = append(executed, call)
+			results = append(results, ToolResult{ID: call.ID, Name: call.Name, Output: res})
+		}
+
+		resultsJSON, err := json.Marshal(results)
+		if err != nil {
+			return "", executed, err
+		}
+
+		messages = append(messages,
+			Message{Role: "assistant", Content: out},
+			Message{Role: "user", Content: "Tool results: " + string(resultsJSON)},
+		)
+	}
+
+	return "", executed, fmt.Errorf("tool loop exceeded %d iterations without a final answer", maxIterations)
+}
@@ -0,0 +1,80 @@
+package assembllm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseToolCalls(t *testing.T) {
+	t.Parallel()
+
+	tools := []Tool{
+		{Name: "weather", Script: "args.location"},
+		{Name: "sentiment"},
+	}
+
+	tests := []struct {
+		response string
+		calls    int
+		ok       bool
+	}{
+		{`[{"name": "weather", "input": {"location": "Austin TX"}}]`, 1, true},
+		{`{"id": "1", "name": "weather", "input": {"location": "Austin TX"}}`, 1, true},
+		{`[{"name": "sentiment", "input": {"positive_score": 0.9}}]`, 0, false},
+		{`[{"name": "unknown", "input": {}}]`, 0, false},
+		{`It is sunny in Austin today.`, 0, false},
+		{`[]`, 0, false},
+	}
+
+	for _, tt := range tests {
+		calls, ok := parseToolCalls(tt.response, tools)
+		if ok != tt.ok || len(calls) != tt.calls {
+			t.Fatalf("%s: want %d calls and %v, got %d calls and %v", tt.response, tt.calls, tt.ok, len(calls), ok)
+		}
+	}
+}
+
+func TestRunScriptTool(t *testing.T) {
+	t.Parallel()
+
+	tool := Tool{Name: "weather", Script: `"forecast for " + args.location`}
+	call := ToolCall{Name: "weather", Input: map[string]interface{}{"location": "Austin TX"}}
+
+	got, err := scriptContext{}.runTool(tool, call)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	want := "forecast for Austin TX"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestToolLoopLimitsModelCalls(t *testing.T) {
+	t.Parallel()
+
+	// The model asks for the weather every time it's called, with or without tools
+	source := filepath.Join(t.TempDir(), "weather.wasm")
+	module := constantModule(`{"name":"weather","input":{"location":"Austin TX"}}`, "completionWithTools", "chat")
+	if err := os.WriteFile(source, module, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pluginCfg := CompletionPluginConfig{Name: "weather", Source: source}
+	tools := []Tool{{Name: "weather", Script: `"sunny in " + args.location`}}
+
+	for _, maxIterations := range []int{1, 3} {
+		got, executed, err := scriptContext{}.generateResponseWithToolLoop(pluginCfg, "what's the weather?", tools, maxIterations)
+		want := fmt.Sprintf("tool loop exceeded %d iterations", maxIterations)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("want %s, got %q, %v", want, got, err)
+		}
+		// Each model call asked for one tool call
+		if len(executed) != maxIterations {
+			t.Fatalf("want %d model calls, got %d", maxIterations, len(executed))
+		}
+	}
+}
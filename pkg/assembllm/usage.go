@@ -0,0 +1,90 @@
+package assembllm
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Token counts for a plugin call, reported by plugins that return a response envelope
+type Usage struct {
+	Plugin       string  `json:"plugin,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost,omitempty"`
+}
+
+// Adds the tokens and cost of another call, keeping the latest plugin and model
+func (u *Usage) Add(other Usage) {
+	u.Plugin = other.Plugin
+	u.Model = other.Model
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.Cost += other.Cost
+}
+
+// Price of a model in US dollars per million tokens
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// A response with metadata, returned by plugins that opt in when the host sets the envelope config
+type envelope struct {
+	Response *string `json:"response"`
+	Model    string  `json:"model"`
+	Usage    *struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// Unwraps a response envelope, returning the output unchanged when it isn't one
+func unwrapEnvelope(out string) (string, *envelope) {
+	trimmed := bytes.TrimSpace([]byte(out))
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return out, nil
+	}
+
+	var env envelope
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&env); err != nil || env.Response == nil {
+		return out, nil
+	}
+
+	return *env.Response, &env
+}
+
+// Reports the usage from an envelope to the OnUsage callback, priced with the plugin's pricing
+func (p CompletionPluginConfig) reportUsage(env *envelope) {
+	if p.OnUsage == nil || env == nil || env.Usage == nil {
+		return
+	}
+
+	model := env.Model
+	if model == "" {
+		model = p.Model
+	}
+
+	usage := Usage{
+		Plugin:       p.Name,
+		Model:        model,
+		InputTokens:  env.Usage.InputTokens,
+		OutputTokens: env.Usage.OutputTokens,
+	}
+	if price, ok := p.price(model); ok {
+		usage.Cost = (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1_000_000
+	}
+
+	p.OnUsage(usage)
+}
+
+// Gets the price of the model, falling back to the plugin's default price
+func (p CompletionPluginConfig) price(model string) (Price, bool) {
+	if price, ok := p.Pricing[model]; ok {
+		return price, true
+	}
+	price, ok := p.Pricing["default"]
+	return price, ok
+}
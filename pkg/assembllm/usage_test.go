@@ -0,0 +1,47 @@
+package assembllm
+
+import "testing"
+
+func TestUnwrapEnvelope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		out      string
+		response string
+		usage    bool
+	}{
+		{`hello`, `hello`, false},
+		{`{"response": "hello", "model": "gpt-4o", "usage": {"input_tokens": 10, "output_tokens": 2}}`, `hello`, true},
+		{`{"response": "hello"}`, `hello`, false},
+		{`{"name": "weather", "input": {"location": "Austin TX"}}`, `{"name": "weather", "input": {"location": "Austin TX"}}`, false},
+		{`{"response": "hello", "other": true}`, `{"response": "hello", "other": true}`, false},
+	}
+
+	for _, tt := range tests {
+		got, env := unwrapEnvelope(tt.out)
+		if got != tt.response {
+			t.Fatalf("want %s, got %s", tt.response, got)
+		}
+		if hasUsage := env != nil && env.Usage != nil; hasUsage != tt.usage {
+			t.Fatalf("%s: want usage %v, got %v", tt.out, tt.usage, hasUsage)
+		}
+	}
+}
+
+func TestReportUsageCost(t *testing.T) {
+	t.Parallel()
+
+	var got Usage
+	pluginCfg := CompletionPluginConfig{
+		Name:    "openai",
+		Pricing: map[string]Price{"default": {Input: 1, Output: 2}, "gpt-4o": {Input: 5, Output: 15}},
+		OnUsage: func(u Usage) { got.Add(u) },
+	}
+
+	_, env := unwrapEnvelope(`{"response": "hi", "model": "gpt-4o", "usage": {"input_tokens": 1000000, "output_tokens": 100000}}`)
+	pluginCfg.reportUsage(env)
+
+	if got.Model != "gpt-4o" || got.InputTokens != 1000000 || got.Cost != 6.5 {
+		t.Fatalf("want gpt-4o costing 6.5, got %+v", got)
+	}
+}
@@ -0,0 +1,317 @@
+package assembllm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"gopkg.in/yaml.v3"
+)
+
+// A problem found while validating a workflow
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+var (
+	yamlLineError   = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	jsonSchemaTypes = map[string]bool{
+		"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true, "null": true,
+	}
+)
+
+// Validates a workflow file, see ValidateWorkflow
+func (c *Client) ValidateWorkflowFile(path string) ([]Diagnostic, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := c.ValidateWorkflow(data)
+	for i := range diagnostics {
+		diagnostics[i].File = path
+	}
+	return diagnostics, nil
+}
+
+// Validates a workflow without running it, reporting unknown keys, scripts and prompts that don't compile,
+// plugins missing from the client's configuration, malformed tool schemas, and invalid task dependencies
+func (c *Client) ValidateWorkflow(data []byte) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return yamlDiagnostics(err)
+	}
+
+	var tasks Tasks
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&tasks); err != nil {
+		diagnostics = append(diagnostics, yamlDiagnostics(err)...)
+
+		// Fall back to a lenient decode so the remaining checks can still run
+		tasks = Tasks{}
+		if err := yaml.Unmarshal(data, &tasks); err != nil {
+			return diagnostics
+		}
+	}
+
+	report := func(node *yaml.Node, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Line:    node.Line,
+			Column:  node.Column,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	sc := scriptContext{}
+
+	if tasks.IterationValuesIn != "" {
+		env := scriptFunctions(context.Background(), "")
+		env["vars"] = map[string]interface{}{}
+		env["inputs"] = map[string]interface{}{}
+		if err := compileScript(tasks.IterationValuesIn, env); err != nil {
+			report(findNode(&root, "iterator_script"), "iterator_script: %v", err)
+		}
+	}
+
+	if _, err := parseTimeout(tasks.Timeout); err != nil {
+		report(findNode(&root, "timeout"), "invalid workflow timeout: %v", err)
+	}
+
+	inputNames := map[string]bool{}
+	for j, in := range tasks.Inputs {
+		node := findNode(&root, "inputs", j)
+		for _, problem := range validateInput(in) {
+			report(node, "input %s: %s", in.Name, problem)
+		}
+		if inputNames[in.Name] {
+			report(node, "duplicate input name: %s", in.Name)
+		}
+		inputNames[in.Name] = true
+	}
+
+	if len(tasks.Tasks) == 0 {
+		report(findNode(&root), "workflow has no tasks")
+	}
+
+	for i, task := range tasks.Tasks {
+		label := task.Name
+		if label == "" {
+			label = "#" + strconv.Itoa(i+1)
+		}
+
+		if task.PreScript != "" {
+			if err := compileScript(task.PreScript, sc.env("", nil)); err != nil {
+				report(findNode(&root, "tasks", i, "pre_script"), "task %s pre_script: %v", label, err)
+			}
+		}
+
+		if task.PostScript != "" {
+			if err := compileScript(task.PostScript, sc.env("", nil)); err != nil {
+				report(findNode(&root, "tasks", i, "post_script"), "task %s post_script: %v", label, err)
+			}
+		}
+
+		for _, field := range []struct{ name, text string }{{"prompt", task.Prompt}, {"role", task.Role}} {
+			if strings.Contains(field.text, "{{") {
+				if _, err := parseTemplate(field.name, field.text, sc.templateFuncs()); err != nil && meantAsTemplate(field.text) {
+					report(findNode(&root, "tasks", i, field.name), "task %s %s: %v", label, field.name, err)
+				}
+			}
+		}
+
+		if task.Plugin != "" {
+			if _, err := c.Plugins.GetPlugin(task.Plugin); err != nil {
+				report(findNode(&root, "tasks", i, "plugin"), "task %s: %v", label, err)
+			}
+		}
+
+		if err := task.RetryPolicy.validate(); err != nil {
+			report(findNode(&root, "tasks", i), "task %s: %v", label, err)
+		}
+
+		if _, err := parseTimeout(task.Timeout); err != nil {
+			report(findNode(&root, "tasks", i, "timeout"), "task %s: invalid timeout: %v", label, err)
+		}
+
+		if _, err := parseTimeout(task.CacheTTL); err != nil {
+			report(findNode(&root, "tasks", i, "cache_ttl"), "task %s: invalid cache_ttl: %v", label, err)
+		}
+
+		for j, server := range task.MCPServers {
+			if err := server.validate(); err != nil {
+				report(findNode(&root, "tasks", i, "mcp_servers", j), "task %s mcp_servers: %v", label, err)
+			}
+		}
+		if len(task.MCPServers) > 0 && task.Plugin == "" {
+			report(findNode(&root, "tasks", i, "mcp_servers"), "task %s: mcp_servers requires a plugin", label)
+		}
+
+		toolNames := map[string]bool{}
+		for j, tool := range task.Tools {
+			node := findNode(&root, "tasks", i, "tools", j)
+			for _, problem := range validateTool(sc, tool) {
+				report(node, "task %s tool %s: %s", label, tool.Name, problem)
+			}
+			if toolNames[tool.Name] {
+				report(node, "task %s: duplicate tool name: %s", label, tool.Name)
+			}
+			toolNames[tool.Name] = true
+		}
+	}
+
+	if _, err := tasks.topologicalOrder(); err != nil {
+		report(findNode(&root, "tasks"), "%v", err)
+	}
+
+	return diagnostics
+}
+
+// Checks a tool's definition and implementation
+func validateTool(sc scriptContext, tool Tool) []string {
+	var problems []string
+
+	if tool.Name == "" {
+		problems = append(problems, "name is required")
+	}
+
+	schema := tool.InputSchema
+	if schema.Type != "object" {
+		problems = append(problems, fmt.Sprintf("input_schema type must be object, got %q", schema.Type))
+	}
+
+	for name, property := range schema.Properties {
+		if !jsonSchemaTypes[property.Type] {
+			problems = append(problems, fmt.Sprintf("property %s has invalid type %q", name, property.Type))
+		}
+	}
+
+	for _, name := range schema.Required {
+		if _, ok := schema.Properties[name]; !ok {
+			problems = append(problems, fmt.Sprintf("required property %s is not defined", name))
+		}
+	}
+
+	implementations := 0
+	if tool.Script != "" {
+		implementations++
+		env := sc.env("", map[string]interface{}{"args": map[string]interface{}{}})
+		if err := compileScript(tool.Script, env); err != nil {
+			problems = append(problems, fmt.Sprintf("script: %v", err))
+		}
+	}
+	if tool.Extism != nil {
+		implementations++
+		if tool.Extism.Source == "" || tool.Extism.Function == "" {
+			problems = append(problems, "extism requires a source and function")
+		}
+	}
+	if tool.Workflow != "" {
+		implementations++
+	}
+	if implementations > 1 {
+		problems = append(problems, "only one of script, extism, or workflow can be set")
+	}
+
+	return problems
+}
+
+// Compiles a script against the types of the environment
+// Values that are only known at runtime, like iterValue, are typed as interface{} so any use of them compiles
+func compileScript(script string, env map[string]interface{}) error {
+	var fields []reflect.StructField
+	for name, value := range env {
+		t := reflect.TypeOf(value)
+		if t == nil {
+			t = reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		fields = append(fields, reflect.StructField{
+			Name: "F" + strconv.Itoa(len(fields)),
+			Type: t,
+			Tag:  reflect.StructTag(`expr:"` + name + `"`),
+		})
+	}
+
+	typedEnv := reflect.New(reflect.StructOf(fields)).Elem().Interface()
+	if _, err := expr.Compile(script, expr.Env(typedEnv)); err != nil {
+		// Keep the message, dropping the source snippet that follows it
+		msg, _, _ := strings.Cut(err.Error(), "\n")
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Converts yaml errors to diagnostics, using the line numbers in their messages
+func yamlDiagnostics(err error) []Diagnostic {
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	var diagnostics []Diagnostic
+	for _, msg := range messages {
+		d := Diagnostic{Message: msg}
+		if m := yamlLineError.FindStringSubmatch(msg); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = m[2]
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// Finds the yaml node at the path of mapping keys and sequence indexes
+// Returns the deepest node found when the full path doesn't exist
+func findNode(root *yaml.Node, path ...interface{}) *yaml.Node {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+
+	return node
+}
@@ -0,0 +1,91 @@
+package assembllm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateExampleWorkflows(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := os.ReadFile("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	paths, _ := filepath.Glob("../../workflows/*.yaml")
+	nested, _ := filepath.Glob("../../workflows/*/*.yaml")
+	for _, path := range append(paths, nested...) {
+		diagnostics, err := client.ValidateWorkflowFile(path)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		for _, d := range diagnostics {
+			t.Errorf("%s", d)
+		}
+	}
+}
+
+func TestValidateWorkflowProblems(t *testing.T) {
+	t.Parallel()
+
+	client, _ := NewClientFromConfig([]byte(testConfig))
+
+	workflow := `
+inputs:
+  - name: tone
+    type: text
+  - name: words
+    type: integer
+    default: many
+tasks:
+  - name: a
+    plugin: missing
+    pre-script: "x"
+    post_script: "unknown(1)"
+    role: "{{ .input | nope }}"
+    depends_on: [b]
+  - name: b
+    prompt: "Fill in {{#each items}}{{name}}{{/each}}"
+    depends_on: [a]
+    mcp_servers:
+      - url: http://example.com/mcp
+    tools:
+      - name: t
+        input_schema:
+          type: object
+          properties:
+            x:
+              type: str
+`
+
+	diagnostics := client.ValidateWorkflow([]byte(workflow))
+
+	wants := []string{
+		"field pre-script not found",
+		`input tone: invalid type "text"`,
+		"input words: default: input words must be an integer",
+		"post_script: unknown name unknown",
+		`role: template: role:1: function "nope" not defined`,
+		"plugin not found: missing",
+		"url must be a local address",
+		"mcp_servers requires a plugin",
+		`property x has invalid type "str"`,
+		"cycle detected",
+	}
+
+	if len(diagnostics) != len(wants) {
+		t.Fatalf("want %d diagnostics, got %v", len(wants), diagnostics)
+	}
+	for i, want := range wants {
+		if !strings.Contains(diagnostics[i].Message, want) || diagnostics[i].Line == 0 {
+			t.Fatalf("want %q with a line number, got %v", want, diagnostics[i])
+		}
+	}
+}
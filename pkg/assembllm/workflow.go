@@ -0,0 +1,488 @@
+package assembllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"gopkg.in/yaml.v3"
+)
+
+type Tasks struct {
+	Name              string        `yaml:"name,omitempty"`
+	Description       string        `yaml:"description,omitempty"`
+	IterationValuesIn string        `yaml:"iterator_script"`
+	IterationValues   []interface{} `yaml:"-"`
+	// Number of iterations to run at once
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Limit on running the workflow, e.g. 5m
+	Timeout string `yaml:"timeout,omitempty"`
+	// Named parameters of the workflow, available to scripts and prompts as inputs
+	Inputs []Input `yaml:"inputs,omitempty"`
+	Tasks  []Task  `yaml:"tasks"`
+}
+
+type Task struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Prompt      string `yaml:"prompt"`
+	Role        string `yaml:"role"`
+	Plugin      string `yaml:"plugin"`
+	Model       string `yaml:"model"`
+	Temperature string `yaml:"temperature"`
+	PreScript   string `yaml:"pre_script"`
+	PostScript  string `yaml:"post_script"`
+	Tools       []Tool `yaml:"tools,omitempty"`
+	// Servers whose tools are offered to the model along with Tools, and executed by them
+	MCPServers []MCPServer `yaml:"mcp_servers,omitempty"`
+	// Names of the tasks whose outputs this task consumes
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// Limit on model calls when executing tool implementations, the task fails when it is reached
+	MaxIterations int `yaml:"max_iterations,omitempty"`
+	// Overrides the plugin's retry policy for this task
+	RetryPolicy `yaml:",inline"`
+	// Limit on running the task, including its scripts, tools, and retries, e.g. 90s
+	Timeout string `yaml:"timeout,omitempty"`
+	// Reuses cached responses for the task's plugin calls
+	Cache bool `yaml:"cache,omitempty"`
+	// How long cached responses are used for this task, e.g. 12h
+	CacheTTL string `yaml:"cache_ttl,omitempty"`
+}
+
+// The outcome of running a single task
+type TaskResult struct {
+	Name   string
+	Plugin string
+	Model  string
+	// The prompt sent to the plugin, after scripts and upstream outputs were applied
+	Prompt   string
+	Response string
+	// Tool calls the model requested, including those executed by the tool loop
+	ToolCalls []ToolCall
+	// Tokens used by the task's plugin calls, for plugins that report usage
+	Usage    Usage
+	Duration time.Duration
+	Err      error
+}
+
+// A parsed workflow bound to the client used to run its tasks
+type Workflow struct {
+	Tasks Tasks
+	// Location of the workflow file, used to resolve relative paths in scripts
+	Path string
+	// Variables available to scripts as vars, set by a calling workflow
+	Vars map[string]interface{}
+	// Values of the workflow's inputs, set with SetInputs
+	Inputs map[string]interface{}
+	client *Client
+	// Absolute paths of the workflows that chained to this one, used to detect cycles
+	callers []string
+}
+
+// Parses a workflow from yaml
+func (c *Client) ParseWorkflow(data []byte) (*Workflow, error) {
+	var tasks Tasks
+	err := yaml.Unmarshal(data, &tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Workflow{Tasks: tasks, client: c}, nil
+}
+
+// Loads a workflow from a yaml reader
+func (c *Client) LoadWorkflow(r io.Reader) (*Workflow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ParseWorkflow(data)
+}
+
+// Loads a workflow from a yaml file, relative paths in scripts resolve against its directory
+func (c *Client) LoadWorkflowFile(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.ParseWorkflow(data)
+	if err != nil {
+		return nil, err
+	}
+	w.Path = path
+
+	return w, nil
+}
+
+func (sc scriptContext) getAbsolutePath(path string) (string, error) {
+	workflowDir := filepath.Dir(sc.workflowPath)
+	joinedPath := filepath.Join(workflowDir, path)
+	return filepath.Abs(joinedPath)
+}
+
+// Runs another workflow in-process, returning its raw output
+// Relative paths resolve against the calling workflow, and optional variables are passed to it as vars
+// and to its inputs of the same name
+func (sc scriptContext) workflowChain(path string, p string, vars ...map[string]interface{}) (string, error) {
+	absPath, err := sc.getAbsolutePath(path)
+	if err != nil {
+		return "", fmt.Errorf("error loading workflow, check filepath: %v", err)
+	}
+
+	for _, caller := range sc.callers {
+		if caller == absPath {
+			chain := append(append([]string{}, sc.callers...), absPath)
+			return "", fmt.Errorf("workflow cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	child, err := sc.client.LoadWorkflowFile(absPath)
+	if err != nil {
+		return "", fmt.Errorf("error loading workflow: %v: %v", absPath, err)
+	}
+
+	child.callers = sc.callers
+	child.Vars = map[string]interface{}{}
+	for _, v := range vars {
+		for k, val := range v {
+			child.Vars[k] = val
+		}
+	}
+
+	inputs := map[string]interface{}{}
+	for _, in := range child.Tasks.Inputs {
+		if val, ok := child.Vars[in.Name]; ok {
+			inputs[in.Name] = val
+		}
+	}
+	if err := child.SetInputs(inputs); err != nil {
+		return "", fmt.Errorf("error running workflow %s: %w", absPath, err)
+	}
+
+	res, err := child.RunContext(sc.context(), p)
+	if err != nil {
+		return "", fmt.Errorf("error running workflow %s: %w", absPath, err)
+	}
+	return res, nil
+}
+
+// Creates the script context for an iteration of the workflow
+func (w *Workflow) newScriptContext(ctx context.Context, iterValue interface{}) scriptContext {
+	callers := append([]string{}, w.callers...)
+	if w.Path != "" {
+		if absPath, err := filepath.Abs(w.Path); err == nil {
+			callers = append(callers, absPath)
+		}
+	}
+
+	// Missing inputs are reported by Iterations, before any iteration runs
+	inputs, _ := w.inputs()
+
+	return scriptContext{
+		ctx:          ctx,
+		client:       w.client,
+		workflowPath: w.Path,
+		iterValue:    iterValue,
+		vars:         w.Vars,
+		inputs:       inputs,
+		callers:      callers,
+	}
+}
+
+// Returns a cancellable context limited by the workflow's timeout, if it has one
+// Run applies the timeout itself, callers running iterations directly use it to bound them
+func (w *Workflow) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	timeout, err := parseTimeout(w.Tasks.Timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid workflow timeout: %v", err)
+	}
+	if timeout == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Evaluates the iterator script, returning the values each iteration of the tasks runs with
+func (w *Workflow) Iterations(ctx context.Context, input string) ([]interface{}, error) {
+	inputs, err := w.inputs()
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Tasks.IterationValuesIn == "" {
+		return []interface{}{nil}, nil
+	}
+
+	env := scriptFunctions(ctx, input)
+	env["vars"] = w.Vars
+	env["inputs"] = inputs
+
+	program, err := expr.Compile(w.Tasks.IterationValuesIn, expr.Env(env), expr.AsKind(reflect.Slice))
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := expr.Run(program, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scripts can return typed slices, such as the []string from split
+	v := reflect.ValueOf(output)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("iterator_script must return an array, got %T", output)
+	}
+
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values, nil
+}
+
+// Runs the workflow's tasks once for the iteration value, stopping when the context is done
+// The input is combined with the prompt of the first task
+func (w *Workflow) RunIteration(ctx context.Context, input string, iterValue interface{}) (string, error) {
+	out, _, err := w.runIteration(ctx, input, iterValue)
+	return out, err
+}
+
+// Runs the workflow's tasks once, also returning the results of the tasks that ran
+func (w *Workflow) runIteration(ctx context.Context, input string, iterValue interface{}) (string, []TaskResult, error) {
+	sc := w.newScriptContext(ctx, iterValue)
+	sc.input = input
+
+	if w.Tasks.hasDependencies() {
+		return w.runGraph(sc, input)
+	}
+
+	var out string
+	var results []TaskResult
+	outputs := map[string]string{}
+
+	for i, task := range w.Tasks.Tasks {
+		taskInput := ""
+		if i == 0 {
+			taskInput = input
+		}
+
+		sc.outputs = outputs
+		result := w.runTask(sc, task, taskInput, out)
+		results = append(results, result)
+		if result.Err != nil {
+			return "", results, result.Err
+		}
+
+		if task.Name != "" {
+			outputs[task.Name] = result.Response
+		}
+		out = result.Response
+	}
+
+	return out, results, nil
+}
+
+// Runs a single task
+// The input is combined with the task's prompt, and prev is the upstream output prepended to it
+func (w *Workflow) runTask(sc scriptContext, task Task, input string, prev string) TaskResult {
+	start := time.Now()
+	result := TaskResult{Name: task.Name, Plugin: task.Plugin, Model: task.Model}
+
+	timeout, err := parseTimeout(task.Timeout)
+	if err != nil {
+		result.Err = fmt.Errorf("invalid timeout for task %s: %v", task.Name, err)
+		return result
+	}
+	parent := sc.context()
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+		sc.ctx = ctx
+	}
+
+	result.Err = w.runTaskSteps(sc, task, input, prev, &result)
+	if result.Err != nil && timeout > 0 && parent.Err() == nil && errors.Is(sc.ctx.Err(), context.DeadlineExceeded) {
+		result.Err = fmt.Errorf("task %s %w after %s", task.Name, ErrTimeout, timeout)
+	}
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// Runs the task's scripts and plugin call, recording the prompt, response, and tool calls in the result
+func (w *Workflow) runTaskSteps(sc scriptContext, task Task, input string, prev string, result *TaskResult) error {
+	role, _, err := sc.expandTemplate("role", task.Role, prev)
+	if err != nil {
+		return fmt.Errorf("error in role for task %s: %v", task.Name, err)
+	}
+	task.Role = role
+
+	prompt, refs, err := sc.expandTemplate("prompt", task.Prompt, prev)
+	if err != nil {
+		return fmt.Errorf("error in prompt for task %s: %v", task.Name, err)
+	}
+	task.Prompt = prompt
+
+	// A template that places the input or previous output gets it only where it's placed
+	if refs["input"] {
+		input = ""
+	}
+	if refs["previous"] {
+		prev = ""
+	}
+
+	if input != "" {
+		task.Prompt = input + " " + task.Prompt
+	}
+
+	if task.PreScript != "" {
+		s, err := sc.runExpr(task.Prompt, task.PreScript)
+		if err != nil {
+			return err
+		}
+		task.Prompt = task.Prompt + s
+	}
+	result.Prompt = task.Prompt
+
+	var res string
+	if task.Plugin != "" {
+		pluginCfg, err := w.client.Plugin(task.Plugin)
+		if err != nil {
+			return err
+		}
+		if task.Temperature != "" {
+			pluginCfg.Temperature = task.Temperature
+		}
+
+		pluginCfg.Role = task.Role
+		pluginCfg.Model = task.Model
+		pluginCfg.RetryPolicy = pluginCfg.RetryPolicy.merge(task.RetryPolicy)
+		if err := w.setTaskCache(&pluginCfg, task); err != nil {
+			return err
+		}
+		onUsage := pluginCfg.OnUsage
+		pluginCfg.OnUsage = func(usage Usage) {
+			result.Usage.Add(usage)
+			if onUsage != nil {
+				onUsage(usage)
+			}
+		}
+		prompt := prev + task.Prompt
+		result.Prompt = prompt
+
+		if len(task.MCPServers) > 0 {
+			tools, closeServers, err := sc.connectMCPServers(task.MCPServers, task.Tools)
+			defer closeServers()
+			if err != nil {
+				return fmt.Errorf("task %s: %v", task.Name, err)
+			}
+			task.Tools = tools
+		}
+
+		if hasImplementations(task.Tools) {
+			res, result.ToolCalls, err = sc.generateResponseWithToolLoop(pluginCfg, prompt, task.Tools, task.MaxIterations)
+			if err != nil {
+				return err
+			}
+		} else if task.Tools != nil {
+			res, err = pluginCfg.GenerateResponseWithToolsContext(sc.context(), prompt, task.Tools)
+			if err != nil {
+				return err
+			}
+			result.ToolCalls, _ = decodeToolCalls(res)
+		} else {
+			res, err = pluginCfg.GenerateResponseContext(sc.context(), prompt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if task.PostScript != "" {
+		s, err := sc.runExpr(res, task.PostScript)
+		if err != nil {
+			return err
+		}
+		res = s
+	}
+
+	result.Response = res
+	return nil
+}
+
+// Uses the client's cache for tasks that enable caching, with the task's TTL when it sets one
+func (w *Workflow) setTaskCache(pluginCfg *CompletionPluginConfig, task Task) error {
+	if w.client.Cache == nil || (!task.Cache && pluginCfg.Cache == nil) {
+		return nil
+	}
+
+	cache := *w.client.Cache
+	if task.CacheTTL != "" {
+		ttl, err := time.ParseDuration(task.CacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid cache_ttl for task %s: %v", task.Name, err)
+		}
+		cache.TTL = ttl
+	}
+	pluginCfg.Cache = &cache
+
+	return nil
+}
+
+// Runs the workflow for every iteration value, returning the combined output
+func (w *Workflow) Run(input string) (string, error) {
+	return w.RunContext(context.Background(), input)
+}
+
+// Runs the workflow for every iteration value, stopping when the context is done or the workflow times out
+func (w *Workflow) RunContext(ctx context.Context, input string) (string, error) {
+	ctx, cancel, err := w.WithTimeout(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	values, err := w.Iterations(ctx, input)
+	if err != nil {
+		return "", w.timeoutError(ctx, err)
+	}
+
+	var out string
+	for _, r := range w.StartIterations(ctx, input, values, 0) {
+		res, err := r.Wait()
+		if err != nil {
+			return "", w.timeoutError(ctx, err)
+		}
+		out += res
+	}
+
+	return out, nil
+}
+
+// Reports that the workflow timed out when its context's deadline caused the error
+func (w *Workflow) timeoutError(ctx context.Context, err error) error {
+	if w.Tasks.Timeout != "" && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("workflow %w after %s", ErrTimeout, w.Tasks.Timeout)
+	}
+	return err
+}
+
+// Parses a timeout such as 30s or 5m, an empty timeout is no limit
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(timeout)
+}
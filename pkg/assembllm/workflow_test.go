@@ -0,0 +1,142 @@
+package assembllm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeWorkflow(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWorkflowChaining(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeWorkflow(t, dir, "nested/child.yaml", `
+tasks:
+  - post_script: "vars.greeting + ', ' + iterValue"
+iterator_script: "[input]"
+`)
+	parent := writeWorkflow(t, dir, "parent.yaml", `
+tasks:
+  - post_script: "Workflow('nested/child.yaml', 'world', {'greeting': 'hello'})"
+`)
+
+	client := NewClient(CompletionPluginConfigs{})
+	w, err := client.LoadWorkflowFile(parent)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	got, err := w.Run("")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	want := "hello, world"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestWorkflowChainingCycle(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeWorkflow(t, dir, "b.yaml", `
+tasks:
+  - post_script: "Workflow('a.yaml', input)"
+`)
+	a := writeWorkflow(t, dir, "a.yaml", `
+tasks:
+  - post_script: "Workflow('b.yaml', input)"
+`)
+
+	client := NewClient(CompletionPluginConfigs{})
+	w, err := client.LoadWorkflowFile(a)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	_, err = w.Run("")
+	if err == nil || !strings.Contains(err.Error(), "workflow cycle detected") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	workflow := `
+tasks:
+  - name: slow
+    timeout: 50ms
+    pre_script: Get("` + server.URL + `")
+`
+
+	client, err := NewClientFromConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	_, err = client.RunWorkflow(strings.NewReader(workflow), "")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("want timeout error, got %v", err)
+	}
+}
+
+func TestIterationTaskResults(t *testing.T) {
+	t.Parallel()
+
+	workflow := `
+tasks:
+  - name: first
+    post_script: '"A"'
+  - name: second
+    post_script: 'outputs.first + "B"'
+`
+
+	client, err := NewClientFromConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	w, err := client.ParseWorkflow([]byte(workflow))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	results := w.StartIterations(context.Background(), "", []interface{}{nil}, 0)
+	if _, err := results[0].Wait(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	tasks := results[0].Tasks()
+	if len(tasks) != 2 {
+		t.Fatalf("want 2 task results, got %d", len(tasks))
+	}
+
+	if tasks[0].Name != "first" || tasks[1].Response != "AB" {
+		t.Fatalf("want first and AB, got %s and %s", tasks[0].Name, tasks[1].Response)
+	}
+}
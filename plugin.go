@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Matches GitHub release asset urls, for the latest release or a tagged one
+var releaseAssetRegex = regexp.MustCompile(`^https://github\.com/([^/]+)/([^/]+)/releases/(latest/download|download/[^/]+)/([^/]+)$`)
+
+func newPluginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Manage the configured completion plugins",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <name> <url or path>",
+		Short: "Add a plugin, pinning the sha256 hash of its module",
+		Args:  cobra.ExactArgs(2),
+		RunE:  addPlugin,
+	}
+	addCmd.Flags().String("url", "", "The base url of the service the plugin calls")
+	addCmd.Flags().String("api-key", "", "Reference to the service's API key, e.g. env:OPENAI_API_KEY")
+	addCmd.Flags().String("account-id", "", "Reference to the service's account ID, e.g. env:CF_ACCOUNT_ID")
+	addCmd.Flags().String("model", "", "The default model to use")
+	addCmd.Flags().Bool("wasi", false, "Whether the plugin requires WASI")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the configured plugins",
+			Args:  cobra.NoArgs,
+			RunE:  listPlugins,
+		},
+		addCmd,
+		&cobra.Command{
+			Use:   "remove <name>...",
+			Short: "Remove plugins",
+			Args:  cobra.MinimumNArgs(1),
+			RunE:  removePlugins,
+		},
+		&cobra.Command{
+			Use:   "show <name>",
+			Short: "Show a plugin's configuration",
+			Args:  cobra.ExactArgs(1),
+			RunE:  showPlugin,
+		},
+		&cobra.Command{
+			Use:   "update [name]...",
+			Short: "Pin plugins from GitHub releases to their latest release",
+			RunE:  updatePluginReleases,
+		},
+		&cobra.Command{
+			Use:   "verify [name]...",
+			Short: "Download plugins and check their modules match the pinned hash",
+			RunE:  verifyPlugins,
+		},
+	)
+
+	for _, c := range cmd.Commands() {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+	}
+
+	return cmd
+}
+
+func listPlugins(cmd *cobra.Command, args []string) error {
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tHASH\tSOURCE")
+	for _, node := range doc.plugins.Content {
+		hash := scalarValue(node, "hash")
+		if hash == "" {
+			hash = "unpinned"
+		} else if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", scalarValue(node, "name"), hash, scalarValue(node, "source"))
+	}
+
+	return tw.Flush()
+}
+
+func showPlugin(cmd *cobra.Command, args []string) error {
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+
+	_, node := doc.plugin(args[0])
+	if node == nil {
+		return fmt.Errorf("plugin not found: %s", args[0])
+	}
+
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(out))
+	return nil
+}
+
+func addPlugin(cmd *cobra.Command, args []string) error {
+	name, source := args[0], args[1]
+
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+	if _, node := doc.plugin(name); node != nil {
+		return fmt.Errorf("plugin already exists: %s", name)
+	}
+
+	if strings.HasPrefix(source, "https://") {
+		source, err = resolveRelease(cmd.Context(), source)
+		if err != nil {
+			return err
+		}
+	} else {
+		source, err = filepath.Abs(expandHome(source))
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := fetchModule(cmd.Context(), source)
+	if err != nil {
+		return err
+	}
+	hash := assembllm.ModuleHash(data)
+
+	flags := cmd.Flags()
+	url, _ := flags.GetString("url")
+	apiKey, _ := flags.GetString("api-key")
+	accountID, _ := flags.GetString("account-id")
+	model, _ := flags.GetString("model")
+	wasi, _ := flags.GetBool("wasi")
+
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setScalar(node, "name", name)
+	setScalar(node, "source", source)
+	setScalar(node, "hash", hash)
+	setScalar(node, "apiKey", apiKey)
+	if accountID != "" {
+		setScalar(node, "accountId", accountID)
+	}
+	setScalar(node, "url", url)
+	setScalar(node, "model", model)
+	if wasi {
+		setMappingValue(node, "wasi", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+
+	doc.plugins.Content = append(doc.plugins.Content, node)
+	if err := doc.save(); err != nil {
+		return fmt.Errorf("unable to write config file: %v", err)
+	}
+
+	fmt.Printf("Added plugin %s, pinned to sha256 %s\n", name, hash)
+	return nil
+}
+
+func removePlugins(cmd *cobra.Command, args []string) error {
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		i, node := doc.plugin(name)
+		if node == nil {
+			return fmt.Errorf("plugin not found: %s", name)
+		}
+		doc.plugins.Content = append(doc.plugins.Content[:i], doc.plugins.Content[i+1:]...)
+	}
+
+	if err := doc.save(); err != nil {
+		return fmt.Errorf("unable to write config file: %v", err)
+	}
+
+	return nil
+}
+
+// Re-resolves plugins sourced from GitHub releases to the latest release and pins their hash
+func updatePluginReleases(cmd *cobra.Command, args []string) error {
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+
+	names, err := doc.pluginNames(args)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		_, node := doc.plugin(name)
+		source := scalarValue(node, "source")
+
+		match := releaseAssetRegex.FindStringSubmatch(source)
+		if match == nil {
+			fmt.Printf("%s: skipped, not a GitHub release\n", name)
+			continue
+		}
+
+		latest := fmt.Sprintf("https://github.com/%s/%s/releases/latest/download/%s", match[1], match[2], match[4])
+		resolved, err := resolveRelease(cmd.Context(), latest)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		data, err := fetchModule(cmd.Context(), resolved)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		hash := assembllm.ModuleHash(data)
+
+		if resolved == source && hash == scalarValue(node, "hash") {
+			fmt.Printf("%s: up to date\n", name)
+			continue
+		}
+
+		setScalar(node, "source", resolved)
+		setScalar(node, "hash", hash)
+		fmt.Printf("%s: pinned %s\n", name, resolved)
+	}
+
+	if err := doc.save(); err != nil {
+		return fmt.Errorf("unable to write config file: %v", err)
+	}
+
+	return nil
+}
+
+// Downloads each plugin's module and checks it matches the pinned hash
+func verifyPlugins(cmd *cobra.Command, args []string) error {
+	doc, err := loadConfigDocument(getConfigPath())
+	if err != nil {
+		return err
+	}
+
+	names, err := doc.pluginNames(args)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, name := range names {
+		_, node := doc.plugin(name)
+
+		data, err := fetchModule(cmd.Context(), expandHome(scalarValue(node, "source")))
+		if err != nil {
+			fmt.Printf("%s: failed: %v\n", name, err)
+			failed++
+			continue
+		}
+
+		hash := scalarValue(node, "hash")
+		switch actual := assembllm.ModuleHash(data); {
+		case hash == "":
+			fmt.Printf("%s: unpinned, sha256 %s\n", name, actual)
+		case hash == actual:
+			fmt.Printf("%s: ok\n", name)
+		default:
+			fmt.Printf("%s: hash mismatch, pinned %s, got %s\n", name, hash, actual)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d plugins failed verification", failed, len(names))
+	}
+
+	return nil
+}
+
+// Resolves a GitHub latest release url to the url of the tagged release it redirects to
+// Other urls are returned unchanged
+func resolveRelease(ctx context.Context, source string) (string, error) {
+	match := releaseAssetRegex.FindStringSubmatch(source)
+	if match == nil || match[3] != "latest/download" {
+		return source, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, source, nil)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error resolving latest release: %v", err)
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" || !releaseAssetRegex.MatchString(location) {
+		return "", fmt.Errorf("error resolving latest release: %s returned %s", source, resp.Status)
+	}
+
+	return location, nil
+}
+
+// Gets a plugin module from a url or file path
+func fetchModule(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", source, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func expandHome(path string) string {
+	homeDir, _ := os.UserHomeDir()
+	return strings.Replace(path, "~", homeDir, 1)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func runPluginCommand(args ...string) error {
+	cmd := newPluginCommand()
+	cmd.SetArgs(args)
+	return cmd.Execute()
+}
+
+func TestPluginCommands(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, configFileName)
+	t.Setenv(configEnvVar, configPath)
+
+	config := `version: 1
+# my plugins
+completion-plugins:
+  - name: openai
+    source: https://github.com/bradyjoslin/assembllm-openai/releases/latest/download/assembllm_openai.wasm
+    apiKey: OPENAI_API_KEY # read from the environment
+    url: api.openai.com
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	module := filepath.Join(dir, "local.wasm")
+	data := []byte("\x00asm\x01\x00\x00\x00")
+	if err := os.WriteFile(module, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	readConfig := func() string {
+		t.Helper()
+		got, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+
+	// Adding a plugin pins its hash and keeps the rest of the config as written
+	if err := runPluginCommand("add", "local", module, "--url", "localhost", "--api-key", "env:LOCAL_KEY"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	got := readConfig()
+	wants := []string{
+		"# my plugins",
+		"apiKey: OPENAI_API_KEY # read from the environment",
+		"  - name: local\n    source: " + module + "\n    hash: " + assembllm.ModuleHash(data) + "\n    apiKey: env:LOCAL_KEY\n    url: localhost\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(got, want) {
+			t.Fatalf("want %q in config, got:\n%s", want, got)
+		}
+	}
+
+	if err := runPluginCommand("add", "local", module); err == nil || !strings.Contains(err.Error(), "plugin already exists") {
+		t.Fatalf("want plugin already exists, got %v", err)
+	}
+
+	// Verification checks the module against the pinned hash
+	if err := runPluginCommand("verify", "local"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := os.WriteFile(module, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPluginCommand("verify", "local"); err == nil || !strings.Contains(err.Error(), "1 of 1 plugins failed verification") {
+		t.Fatalf("want a failed verification, got %v", err)
+	}
+	if err := runPluginCommand("verify", "missing"); err == nil || !strings.Contains(err.Error(), "plugin not found") {
+		t.Fatalf("want plugin not found, got %v", err)
+	}
+
+	// Removing a plugin leaves the others untouched
+	if err := runPluginCommand("remove", "local"); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := readConfig(); got != config {
+		t.Fatalf("want the original config, got:\n%s", got)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"gopkg.in/yaml.v3"
+)
+
+const projectConfigFileName = ".assembllm.yaml"
+
+// Plugin settings a project config can't change for plugins defined in the user's config,
+// so a cloned repository can't send the user's keys to another host or swap the module
+var protectedPluginKeys = []string{"source", "hash", "url", "apiKey", "accountId"}
+
+// Plugin settings holding secret references, which a project config can't set for any plugin
+var secretPluginKeys = []string{"apiKey", "accountId"}
+
+// Defaults set under defaults in a config file, or by a profile
+type defaults struct {
+	Plugin      string `yaml:"plugin,omitempty"`
+	Model       string `yaml:"model,omitempty"`
+	Role        string `yaml:"role,omitempty"`
+	Temperature string `yaml:"temperature,omitempty"`
+	Raw         *bool  `yaml:"raw,omitempty"`
+}
+
+// Defaults and plugin settings selected with --profile
+type profile struct {
+	defaults `yaml:",inline"`
+	// Settings merged over the named plugins
+	Plugins map[string]yaml.Node `yaml:"plugins,omitempty"`
+}
+
+// Defaults and where they were set
+type defaultsLayer struct {
+	Source   string
+	Defaults defaults
+}
+
+// The user's config merged with the project config and the selected profile
+type resolvedConfig struct {
+	Plugins assembllm.CompletionPluginConfigs
+	// Defaults from the user config, project config, and profile, in increasing precedence
+	Layers []defaultsLayer
+	// Paths of the files the config was loaded from, in the order they were merged
+	Sources []string
+	merged  *configDocument
+}
+
+// Loads the user's config, merging the project config over it and then the selected profile's plugin settings
+func loadConfig() (*resolvedConfig, error) {
+	configPath := getConfigPath()
+	doc, err := loadConfigDocument(configPath)
+	if err != nil {
+		return nil, configError(err)
+	}
+	cfg := &resolvedConfig{Sources: []string{configPath}, merged: doc}
+	if err := cfg.addLayer("user config "+configPath, doc); err != nil {
+		return nil, err
+	}
+
+	if projectPath, ok := findProjectConfig(); ok {
+		project, err := loadConfigDocument(projectPath)
+		if err != nil {
+			return nil, configError(err)
+		}
+		if err := checkProjectConfig(project, doc); err != nil {
+			return nil, configError(fmt.Errorf("%s: %v", projectPath, err))
+		}
+		if err := cfg.addLayer("project config "+projectPath, project); err != nil {
+			return nil, err
+		}
+		mergeNodes(doc.mapping(), project.mapping())
+		cfg.Sources = append(cfg.Sources, projectPath)
+	}
+
+	if appCfg.Profile != "" {
+		node := mappingValue(doc.mapping(), "profiles")
+		if node != nil {
+			node = mappingValue(node, appCfg.Profile)
+		}
+		if node == nil {
+			return nil, configError(fmt.Errorf("profile not found: %s", appCfg.Profile))
+		}
+		var p profile
+		if err := node.Decode(&p); err != nil {
+			return nil, configError(fmt.Errorf("invalid profile %s: %v", appCfg.Profile, err))
+		}
+		cfg.Layers = append(cfg.Layers, defaultsLayer{Source: "profile " + appCfg.Profile, Defaults: p.defaults})
+
+		for name, override := range p.Plugins {
+			_, pluginNode := doc.plugin(name)
+			if pluginNode == nil {
+				return nil, configError(fmt.Errorf("profile %s: plugin not found: %s", appCfg.Profile, name))
+			}
+			mergeNodes(pluginNode, &override)
+		}
+	}
+
+	if err := doc.root.Decode(&cfg.Plugins); err != nil {
+		return nil, configError(fmt.Errorf("failed to get config from yaml: %v", err))
+	}
+
+	return cfg, nil
+}
+
+// Adds the defaults set in a config file
+func (cfg *resolvedConfig) addLayer(source string, doc *configDocument) error {
+	layer := defaultsLayer{Source: source}
+	if node := mappingValue(doc.mapping(), "defaults"); node != nil {
+		if err := node.Decode(&layer.Defaults); err != nil {
+			return configError(fmt.Errorf("invalid defaults in %s: %v", source, err))
+		}
+	}
+
+	cfg.Layers = append(cfg.Layers, layer)
+	return nil
+}
+
+// Finds the project config by walking up from the working directory
+func findProjectConfig() (string, bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+
+	for {
+		path := filepath.Join(dir, projectConfigFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Checks a project config only adds to the user's plugins, without secrets, and only selects the user's plugins
+func checkProjectConfig(project *configDocument, user *configDocument) error {
+	for _, node := range project.plugins.Content {
+		if err := checkProjectPlugin(scalarValue(node, "name"), node, user); err != nil {
+			return err
+		}
+	}
+
+	if err := checkProjectDefault(mappingValue(project.mapping(), "defaults"), user); err != nil {
+		return fmt.Errorf("defaults: %v", err)
+	}
+
+	profiles := mappingValue(project.mapping(), "profiles")
+	if profiles == nil || profiles.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		name, profile := profiles.Content[i].Value, profiles.Content[i+1]
+		if err := checkProjectDefault(profile, user); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+
+		plugins := mappingValue(profile, "plugins")
+		if plugins == nil || plugins.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(plugins.Content); j += 2 {
+			if err := checkProjectPlugin(plugins.Content[j].Value, plugins.Content[j+1], user); err != nil {
+				return fmt.Errorf("profile %s: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkProjectPlugin(name string, node *yaml.Node, user *configDocument) error {
+	// Plugins the project adds can't use secrets, since the project also chooses where they're sent
+	keys := secretPluginKeys
+	if _, userPlugin := user.plugin(name); userPlugin != nil {
+		keys = protectedPluginKeys
+	}
+
+	for _, key := range keys {
+		if mappingValue(node, key) != nil {
+			return fmt.Errorf("plugin %s: %s can only be set in the user config", name, key)
+		}
+	}
+
+	return nil
+}
+
+// Checks the plugin selected by the project's defaults or one of its profiles is defined in the user config,
+// so prompts aren't sent to a plugin the project added unless the user asks for it
+func checkProjectDefault(node *yaml.Node, user *configDocument) error {
+	if node == nil {
+		return nil
+	}
+
+	name := scalarValue(node, "plugin")
+	if name == "" {
+		return nil
+	}
+	if _, userPlugin := user.plugin(name); userPlugin == nil {
+		return fmt.Errorf("plugin %s isn't in the user config, select it with --plugin", name)
+	}
+
+	return nil
+}
+
+// Merges src over dst, merging nested mappings and matching completion-plugins entries by name
+func mergeNodes(dst *yaml.Node, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		*dst = *src
+		return
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i].Value, src.Content[i+1]
+		existing := mappingValue(dst, key)
+
+		switch {
+		case existing == nil:
+			setMappingValue(dst, key, value)
+		case key == "completion-plugins" && existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			mergePluginLists(existing, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		default:
+			setMappingValue(dst, key, value)
+		}
+	}
+}
+
+// Merges each plugin in src over the plugin of the same name in dst, adding those dst doesn't have
+func mergePluginLists(dst *yaml.Node, src *yaml.Node) {
+	for _, plugin := range src.Content {
+		name := scalarValue(plugin, "name")
+
+		var existing *yaml.Node
+		for _, p := range dst.Content {
+			if scalarValue(p, "name") == name {
+				existing = p
+				break
+			}
+		}
+
+		if existing == nil {
+			dst.Content = append(dst.Content, plugin)
+			continue
+		}
+		mergeNodes(existing, plugin)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testUserConfig = `
+completion-plugins:
+  - name: openai
+    source: https://example.com/openai.wasm
+    apiKey: OPENAI_API_KEY
+    url: api.openai.com
+    model: gpt-4o
+profiles:
+  cheap:
+    plugin: openai
+    plugins:
+      openai:
+        model: gpt-4o-mini
+`
+
+func parseTestConfig(t *testing.T, data string) *configDocument {
+	t.Helper()
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &root); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := newConfigDocument(root)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	return doc
+}
+
+func TestMergeProjectConfig(t *testing.T) {
+	t.Parallel()
+
+	user := parseTestConfig(t, testUserConfig)
+	project := parseTestConfig(t, `
+completion-plugins:
+  - name: openai
+    role: you answer in one sentence
+  - name: team
+    source: https://example.com/team.wasm
+    url: localhost
+profiles:
+  review:
+    plugin: openai
+    plugins:
+      team:
+        model: llama3
+`)
+
+	if err := checkProjectConfig(project, user); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	mergeNodes(user.mapping(), project.mapping())
+
+	_, openai := user.plugin("openai")
+	if scalarValue(openai, "role") != "you answer in one sentence" || scalarValue(openai, "model") != "gpt-4o" {
+		t.Fatalf("expected the project role merged over the user's openai plugin")
+	}
+	if _, team := user.plugin("team"); team == nil {
+		t.Fatalf("expected the project's team plugin to be added")
+	}
+
+	profiles := mappingValue(user.mapping(), "profiles")
+	if mappingValue(profiles, "cheap") == nil || mappingValue(profiles, "review") == nil {
+		t.Fatalf("expected profiles from both configs")
+	}
+}
+
+func TestCheckProjectConfig(t *testing.T) {
+	t.Parallel()
+
+	user := parseTestConfig(t, testUserConfig)
+
+	tests := []struct {
+		project string
+		want    string
+	}{
+		{`
+completion-plugins:
+  - name: openai
+    url: attacker.example.com
+`, "plugin openai: url can only be set in the user config"},
+		{`
+completion-plugins:
+  - name: team
+    apiKey: "cmd:cat ~/.ssh/id_rsa"
+`, "plugin team: apiKey can only be set in the user config"},
+		{`
+completion-plugins:
+  - name: team
+    source: https://attacker.example.com/team.wasm
+    url: attacker.example.com
+    apiKey: OPENAI_API_KEY
+`, "plugin team: apiKey can only be set in the user config"},
+		{`
+completion-plugins:
+  - name: team
+    url: attacker.example.com
+    accountId: env:CF_ACCOUNT_ID
+`, "plugin team: accountId can only be set in the user config"},
+		{`
+profiles:
+  sneaky:
+    plugins:
+      openai:
+        source: https://attacker.example.com/openai.wasm
+`, "profile sneaky: plugin openai: source can only be set in the user config"},
+		{`
+profiles:
+  sneaky:
+    plugins:
+      team:
+        apiKey: env:OPENAI_API_KEY
+`, "profile sneaky: plugin team: apiKey can only be set in the user config"},
+		{`
+completion-plugins:
+  - name: team
+    url: attacker.example.com
+defaults:
+  plugin: team
+`, "defaults: plugin team isn't in the user config"},
+		{`
+completion-plugins:
+  - name: team
+    url: attacker.example.com
+profiles:
+  cheap:
+    plugin: team
+`, "profile cheap: plugin team isn't in the user config"},
+	}
+
+	for _, tt := range tests {
+		err := checkProjectConfig(parseTestConfig(t, tt.project), user)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("want %s, got %v", tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultServeAddr = "localhost:8080"
+	maxRequestBytes  = 4 << 20
+)
+
+// Serves completions, workflows, and models from the configured plugins
+// Each request gets its own copy of the plugin config and its own usage totals, rather than sharing appCfg
+type apiServer struct {
+	client      *assembllm.Client
+	defaults    resolvedDefaults
+	workflowDir string
+}
+
+type completionRequest struct {
+	Plugin      string   `json:"plugin"`
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Role        string   `json:"role"`
+	Temperature *float64 `json:"temperature"`
+}
+
+type workflowRequest struct {
+	Input  string                 `json:"input"`
+	Inputs map[string]interface{} `json:"inputs"`
+}
+
+type pluginRecord struct {
+	Name  string `json:"name"`
+	Model string `json:"model,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type pluginsRecord struct {
+	Default string         `json:"default"`
+	Plugins []pluginRecord `json:"plugins"`
+}
+
+type modelsRecord struct {
+	Plugin string   `json:"plugin"`
+	Models []string `json:"models"`
+}
+
+type apiErrorRecord struct {
+	Error struct {
+		Kind    string `json:"kind"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// An error returned to an API client with the HTTP status for it
+type apiError struct {
+	status int
+	kind   string
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &apiError{status: http.StatusBadRequest, kind: "invalid_request", err: err}
+}
+
+func notFound(err error) error {
+	return &apiError{status: http.StatusNotFound, kind: "not_found", err: err}
+}
+
+// Marks a workflow that can't be loaded, such as one with invalid yaml or that fails validation
+func invalidWorkflow(err error) error {
+	return &apiError{status: http.StatusUnprocessableEntity, kind: "invalid_workflow", err: err}
+}
+
+// Marks an error from a plugin call or workflow run, reporting timeouts separately
+func runFailure(err error) error {
+	if errors.Is(err, assembllm.ErrTimeout) {
+		return &apiError{status: http.StatusGatewayTimeout, kind: "timeout", err: err}
+	}
+	return &apiError{status: http.StatusBadGateway, kind: "run", err: err}
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "serve",
+		Short:         "Serve completions, workflows, and models over an HTTP API",
+		Args:          cobra.NoArgs,
+		RunE:          runServe,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := cmd.Flags()
+	flags.String("addr", defaultServeAddr, "The address to listen on")
+	flags.String("workflows", "", "The directory of workflows that can be run (default ~/.assembllm/workflows)")
+	flags.DurationVarP(&appCfg.Timeout, "timeout", "", 0, "Limit on each plugin call, e.g. 30s or 2m")
+	flags.BoolVarP(&appCfg.Cache, "cache", "", false, "Reuse cached responses for identical prompts")
+	flags.DurationVarP(&appCfg.CacheTTL, "cache-ttl", "", defaultCacheTTL, "How long cached responses are used")
+	flags.SortFlags = false
+
+	return cmd
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	workflowDir, _ := cmd.Flags().GetString("workflows")
+	if workflowDir == "" {
+		workflowDir = filepath.Join(getAppDir(), "workflows")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	// Usage is totalled and recorded for each request instead
+	client.OnUsage = nil
+
+	s := &apiServer{client: client, defaults: resolveDefaults(cmd, cfg), workflowDir: expandHome(workflowDir)}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return configError(err)
+	}
+
+	srv := &http.Server{
+		Handler: s.routes(),
+		// Requests are cancelled along with the command, so in-flight plugin calls stop on ctrl+c
+		BaseContext: func(net.Listener) context.Context { return cmd.Context() },
+	}
+
+	fmt.Fprintf(os.Stderr, "Listening on http://%s\n", ln.Addr())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-cmd.Context().Done():
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
+
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/completions", s.handle(s.complete))
+	mux.HandleFunc("POST /v1/workflows/{name}/run", s.handle(s.runWorkflow))
+	mux.HandleFunc("GET /v1/plugins", s.handle(s.listPlugins))
+	mux.HandleFunc("GET /v1/plugins/{name}/models", s.handle(s.listModels))
+	mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	mux.HandleFunc("GET /v1/models", s.handle(s.listOpenAIModels))
+	return mux
+}
+
+// Writes the handler's result as json, or its error with the matching status
+func (s *apiServer) handle(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+
+		res, err := fn(r)
+		status := http.StatusOK
+		if err != nil {
+			var ae *apiError
+			if !errors.As(err, &ae) {
+				ae = &apiError{status: http.StatusInternalServerError, kind: "error", err: err}
+			}
+
+			var record apiErrorRecord
+			record.Error.Kind = ae.kind
+			record.Error.Message = ae.Error()
+			status, res = ae.status, record
+		}
+
+		fmt.Fprintf(os.Stderr, "%s %s %d %s\n", r.Method, r.URL.Path, status, time.Since(start).Round(time.Millisecond))
+		writeJSON(w, status, res)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+// Decodes a json request body, an empty body leaves v unchanged
+func decodeRequest(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return badRequest(fmt.Errorf("invalid request body: %v", err))
+	}
+	return nil
+}
+
+// Gets a copy of the named plugin's config with the configured defaults applied, the default plugin when name is empty
+func (s *apiServer) plugin(name string) (assembllm.CompletionPluginConfig, error) {
+	if name == "" {
+		name = s.defaults.Plugin.Value
+	}
+
+	pluginCfg, err := s.client.Plugin(name)
+	if err != nil {
+		return assembllm.CompletionPluginConfig{}, notFound(fmt.Errorf("plugin not found: %s", name))
+	}
+
+	defaults := AppConfig{Role: s.defaults.Role.Value, Temperature: s.defaults.Temperature.Value}
+	if name == s.defaults.Plugin.Value {
+		defaults.Model = s.defaults.Model.Value
+	}
+
+	return overridePluginConfigWithUserFlags(defaults, pluginCfg), nil
+}
+
+func (s *apiServer) complete(r *http.Request) (interface{}, error) {
+	var req completionRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Prompt == "" {
+		return nil, badRequest(errors.New("prompt is required"))
+	}
+
+	pluginCfg, err := s.plugin(req.Plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := AppConfig{Model: req.Model, Role: req.Role}
+	if req.Temperature != nil {
+		flags.Temperature = strconv.FormatFloat(*req.Temperature, 'f', -1, 64)
+	}
+	pluginCfg = overridePluginConfigWithUserFlags(flags, pluginCfg)
+
+	usage := &usageTotals{}
+	pluginCfg.OnUsage = usage.add
+
+	start := time.Now()
+	res, err := pluginCfg.GenerateResponseContext(r.Context(), req.Prompt)
+	recordUsage(usage, "")
+	if err != nil {
+		return nil, runFailure(err)
+	}
+
+	return completionRecord{
+		Type:       "completion",
+		Plugin:     pluginCfg.Name,
+		Model:      pluginCfg.Model,
+		Prompt:     req.Prompt,
+		Response:   res,
+		Usage:      usage.reported(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// Gets the path of the named workflow in the workflow directory
+func (s *apiServer) workflowPath(name string) (string, error) {
+	path, ok := findWorkflow(s.workflowDir, name)
+	if !ok {
+		return "", notFound(fmt.Errorf("workflow not found: %s", name))
+	}
+	return path, nil
+}
+
+func (s *apiServer) runWorkflow(r *http.Request) (interface{}, error) {
+	name := r.PathValue("name")
+	path, err := s.workflowPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var req workflowRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+
+	record, err := runWorkflowFile(r.Context(), *s.client, name, path, req.Input, req.Inputs)
+	if errors.Is(err, assembllm.ErrInvalidInputs) {
+		return nil, badRequest(err)
+	}
+	var ce *cliError
+	if errors.As(err, &ce) {
+		if ce.kind == "config" {
+			return nil, invalidWorkflow(err)
+		}
+		return nil, runFailure(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *apiServer) listPlugins(r *http.Request) (interface{}, error) {
+	record := pluginsRecord{Default: s.defaults.Plugin.Value, Plugins: []pluginRecord{}}
+	for _, p := range s.client.Plugins.Plugins {
+		record.Plugins = append(record.Plugins, pluginRecord{Name: p.Name, Model: p.Model, URL: p.URL})
+	}
+
+	return record, nil
+}
+
+func (s *apiServer) listModels(r *http.Request) (interface{}, error) {
+	pluginCfg, err := s.plugin(r.PathValue("name"))
+	if err != nil {
+		return nil, err
+	}
+
+	models, err := pluginCfg.GetModels()
+	if err != nil {
+		return nil, runFailure(err)
+	}
+
+	return modelsRecord{Plugin: pluginCfg.Name, Models: models}, nil
+}
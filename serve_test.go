@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestServeRoutes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "greet.yaml"), []byte("tasks:\n  - name: greet\n    pre_script: '\"hello \" + input'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tone := "inputs:\n  - name: tone\n    required: true\ntasks:\n  - name: tone\n    post_script: inputs.tone\n"
+	if err := os.WriteFile(filepath.Join(dir, "tone.yaml"), []byte(tone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("tasks:\n  - name: broken\n    promt: hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "malformed.yaml"), []byte("tasks: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := assembllm.NewClient(assembllm.CompletionPluginConfigs{Plugins: []assembllm.CompletionPluginConfig{{Name: "openai", URL: "api.openai.com"}}})
+	s := &apiServer{client: client, defaults: resolvedDefaults{Plugin: setting{Value: "openai"}}, workflowDir: dir}
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"GET", "/v1/plugins", "", http.StatusOK, `"name":"openai"`},
+		{"GET", "/v1/plugins/missing/models", "", http.StatusNotFound, "plugin not found: missing"},
+		{"POST", "/v1/completions", `{"plugin":"openai"}`, http.StatusBadRequest, "prompt is required"},
+		{"POST", "/v1/completions", `{"promt":"hi"}`, http.StatusBadRequest, "invalid request body"},
+		{"POST", "/v1/workflows/missing/run", "", http.StatusNotFound, "workflow not found: missing"},
+		{"POST", "/v1/workflows/greet/run", `{"input":"world"}`, http.StatusOK, `"prompt":"world hello world`},
+		{"POST", "/v1/workflows/tone/run", `{"inputs":{"tone":"formal"}}`, http.StatusOK, `"response":"formal"`},
+		{"POST", "/v1/workflows/tone/run", `{"input":"hi"}`, http.StatusBadRequest, "missing required input: tone"},
+		{"POST", "/v1/workflows/broken/run", `{"input":"hi"}`, http.StatusUnprocessableEntity, "promt"},
+		{"POST", "/v1/workflows/malformed/run", `{"input":"hi"}`, http.StatusUnprocessableEntity, `"kind":"invalid_workflow"`},
+		{"POST", "/v1/chat/completions", `{"model":"openai/gpt-4o"}`, http.StatusBadRequest, "messages is required"},
+		{"POST", "/v1/chat/completions", `{"model":"missing/gpt-4o","messages":[{"role":"user","content":"hi"}]}`, http.StatusNotFound, "plugin not found: missing"},
+		{"GET", "/v1/models", "", http.StatusOK, `"object":"list"`},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		var body json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if resp.StatusCode != tt.status || !strings.Contains(string(body), tt.want) {
+			t.Fatalf("%s %s: want %d %s, got %d %s", tt.method, tt.path, tt.status, tt.want, resp.StatusCode, body)
+		}
+	}
+}
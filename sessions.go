@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+// A conversation stored between CLI invocations
+type session struct {
+	Name     string              `json:"name"`
+	Plugin   string              `json:"plugin"`
+	Model    string              `json:"model,omitempty"`
+	Updated  time.Time           `json:"updated"`
+	Messages []assembllm.Message `json:"messages"`
+}
+
+func getSessionsDir() string {
+	return filepath.Join(getAppDir(), "sessions")
+}
+
+func getSessionPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid session name: %q", name)
+	}
+	return filepath.Join(getSessionsDir(), name+".json"), nil
+}
+
+// Loads the named session, returning an empty session if it doesn't exist yet
+func loadSession(name string) (*session, error) {
+	path, err := getSessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &session{Name: name}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading session: %v", err)
+	}
+
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error reading session %s: %v", name, err)
+	}
+
+	return &s, nil
+}
+
+func (s *session) save() error {
+	path, err := getSessionPath(s.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create sessions directory: %v", err)
+	}
+
+	s.Updated = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// Sends the prompt with the session's history, saving the exchange to the session
+// Returns what remains to be printed, responses from streaming plugins are printed as they arrive
+func executeSessionCompletion(ctx context.Context, s *session, pc assembllm.CompletionPluginConfig, prompt string, spin bool) (string, error) {
+	messages := append(s.Messages, assembllm.Message{Role: "user", Content: prompt})
+
+	res, streamed, err := generateWithStreaming(ctx, pc, spin, func(ctx context.Context, pc assembllm.CompletionPluginConfig) (string, error) {
+		return pc.GenerateChatResponseContext(ctx, messages)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.Messages = append(messages, assembllm.Message{Role: "assistant", Content: res})
+	s.Plugin = pc.Name
+	s.Model = pc.Model
+	if err := s.save(); err != nil {
+		return "", fmt.Errorf("error saving session: %v", err)
+	}
+
+	if streamed {
+		return "", nil
+	}
+
+	return formatResponse(res, appCfg.Raw), nil
+}
+
+// Formats the session's conversation as markdown
+func (s *session) markdown() string {
+	var sb strings.Builder
+	for _, m := range s.Messages {
+		sb.WriteString("**" + m.Role + "**:\n\n" + m.Content + "\n\n")
+	}
+	return sb.String()
+}
+
+func newSessionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "Manage stored conversation sessions",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List stored sessions",
+			Args:  cobra.NoArgs,
+			RunE:  listSessions,
+		},
+		&cobra.Command{
+			Use:   "rm <name>...",
+			Short: "Remove sessions",
+			Args:  cobra.MinimumNArgs(1),
+			RunE:  removeSessions,
+		},
+	)
+
+	showCmd := &cobra.Command{
+		Use:   "show <name>",
+		Short: "Show a session's conversation",
+		Args:  cobra.ExactArgs(1),
+		RunE:  showSession,
+	}
+	showCmd.Flags().BoolVarP(&appCfg.Raw, "raw", "", false, "Raw output without formatting")
+	cmd.AddCommand(showCmd)
+
+	exportCmd := &cobra.Command{
+		Use:   "export <name>",
+		Short: "Export a session to stdout",
+		Args:  cobra.ExactArgs(1),
+		RunE:  exportSession,
+	}
+	exportCmd.Flags().String("format", "json", "Export format, json or markdown")
+	cmd.AddCommand(exportCmd)
+
+	for _, c := range cmd.Commands() {
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+	}
+
+	return cmd
+}
+
+func listSessions(cmd *cobra.Command, args []string) error {
+	entries, err := os.ReadDir(getSessionsDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sessions []*session
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		s, err := loadSession(name)
+		if err != nil {
+			return err
+		}
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+
+	for _, s := range sessions {
+		fmt.Printf("%-24s %-12s %3d messages  %s\n", s.Name, s.Plugin, len(s.Messages), s.Updated.Format(time.DateTime))
+	}
+
+	return nil
+}
+
+func showSession(cmd *cobra.Command, args []string) error {
+	s, err := loadExistingSession(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(formatResponse(s.markdown(), appCfg.Raw))
+	return nil
+}
+
+func removeSessions(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		path, err := getSessionPath(name)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("session not found: %s", name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func exportSession(cmd *cobra.Command, args []string) error {
+	s, err := loadExistingSession(args[0])
+	if err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "markdown", "md":
+		fmt.Print(s.markdown())
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+
+	return nil
+}
+
+// Loads the named session, returning an error if it doesn't exist
+func loadExistingSession(name string) (*session, error) {
+	path, err := getSessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if !isFile(path) {
+		return nil, fmt.Errorf("session not found: %s", name)
+	}
+	return loadSession(name)
+}
+
+// Check if the path is an existing file
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
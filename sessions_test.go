@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestGetSessionPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"work", true},
+		{"my-session.v2", true},
+		{"", false},
+		{"../escape", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{".hidden", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := getSessionPath(tt.name)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("expected nil, got %v", err)
+				}
+				if want := filepath.Join(getSessionsDir(), tt.name+".json"); path != want {
+					t.Fatalf("want %q, got %q", want, path)
+				}
+			} else if err == nil {
+				t.Fatalf("expected an error for %q, got %q", tt.name, path)
+			}
+		})
+	}
+}
+
+func TestSessionSaveLoad(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Sessions that haven't been saved load empty
+	s, err := loadSession("work")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if s.Name != "work" || len(s.Messages) != 0 {
+		t.Fatalf("want an empty session named work, got %+v", s)
+	}
+
+	s.Plugin = "openai"
+	s.Model = "gpt-4o"
+	s.Messages = []assembllm.Message{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "hi there"},
+	}
+	if err := s.save(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	loaded, err := loadSession("work")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if loaded.Plugin != s.Plugin || loaded.Model != s.Model || !loaded.Updated.Equal(s.Updated) {
+		t.Fatalf("want %+v, got %+v", s, loaded)
+	}
+	if !reflect.DeepEqual(loaded.Messages, s.Messages) {
+		t.Fatalf("want messages %v, got %v", s.Messages, loaded.Messages)
+	}
+
+	if _, err := loadSession("../work"); err == nil {
+		t.Fatalf("expected an error loading an invalid session name")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/charmbracelet/glamour"
+)
+
+// Prints streamed markdown as it arrives, rendering a block at a time unless raw
+type streamPrinter struct {
+	out     io.Writer
+	raw     bool
+	pending string
+	block   strings.Builder
+	inFence bool
+}
+
+func newStreamPrinter(raw bool) *streamPrinter {
+	return &streamPrinter{out: os.Stdout, raw: raw}
+}
+
+// Writes a chunk, printing any blocks it completes
+func (sp *streamPrinter) write(chunk string) {
+	if sp.raw {
+		fmt.Fprint(sp.out, chunk)
+		return
+	}
+
+	sp.pending += chunk
+	for {
+		line, rest, found := strings.Cut(sp.pending, "\n")
+		if !found {
+			return
+		}
+		sp.pending = rest
+		sp.block.WriteString(line + "\n")
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			sp.inFence = !sp.inFence
+		}
+
+		// Blank lines outside of code fences end a markdown block
+		if trimmed == "" && !sp.inFence {
+			sp.printBlock()
+		}
+	}
+}
+
+// Prints whatever remains once the stream has ended
+func (sp *streamPrinter) flush() {
+	if sp.raw {
+		return
+	}
+
+	sp.block.WriteString(sp.pending)
+	sp.pending = ""
+	sp.printBlock()
+}
+
+func (sp *streamPrinter) printBlock() {
+	if strings.TrimSpace(sp.block.String()) == "" {
+		sp.block.Reset()
+		return
+	}
+
+	formatted, err := glamour.Render(sp.block.String(), "dark")
+	if err != nil {
+		formatted = sp.block.String()
+	}
+	fmt.Fprint(sp.out, formatted)
+	sp.block.Reset()
+}
+
+// Shows a spinner while the action runs, replaced in tests
+var runSpinner = createSpinner
+
+// Runs generate behind a spinner, printing chunks as they arrive when the plugin streams
+// Returns the full response and whether it was already printed
+func generateWithStreaming(ctx context.Context, pc assembllm.CompletionPluginConfig, spin bool, generate func(context.Context, assembllm.CompletionPluginConfig) (string, error)) (string, bool, error) {
+	// Structured output only needs the full response
+	if appCfg.Output != "" {
+		res, err := generate(ctx, pc)
+		return res, false, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Once the caller stops reading, after an interrupt, chunks are dropped rather than blocking the plugin
+	chunks := make(chan string)
+	pc.OnChunk = func(chunk string) {
+		select {
+		case chunks <- chunk:
+		case <-ctx.Done():
+		}
+	}
+
+	var res string
+	var err error
+	go func() {
+		defer close(chunks)
+		res, err = generate(ctx, pc)
+	}()
+
+	// Wait for the first chunk, or the full response from plugins that don't stream
+	var first string
+	var streaming bool
+	wait := func() {
+		first, streaming = <-chunks
+	}
+
+	if spin {
+		if spinErr := runSpinner(cancel, wait); spinErr != nil {
+			return "", false, spinErr
+		}
+	} else {
+		wait()
+	}
+
+	if !streaming {
+		return res, false, err
+	}
+
+	printer := newStreamPrinter(appCfg.Raw)
+	printer.write(first)
+	for chunk := range chunks {
+		printer.write(chunk)
+	}
+	printer.flush()
+
+	return res, true, err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/charmbracelet/glamour"
+)
+
+// Renders a block the way the printer does
+func rendered(t *testing.T, block string) string {
+	t.Helper()
+	out, err := glamour.Render(block, "dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestStreamPrinterBlocks(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	sp := &streamPrinter{out: &out}
+
+	// A blank line ends a block, and a partial line waits for the rest
+	sp.write("# Title\n\nSome te")
+	if want := rendered(t, "# Title\n\n"); out.String() != want {
+		t.Fatalf("want the title block printed, got %q", out.String())
+	}
+	if sp.block.Len() != 0 || sp.pending != "Some te" {
+		t.Fatalf("want an empty block and pending %q, got %q and %q", "Some te", sp.block.String(), sp.pending)
+	}
+	printed := out.Len()
+
+	// Blank lines inside a code fence don't end the block
+	sp.write("xt\n```go\nfunc main() {\n\n")
+	if !sp.inFence {
+		t.Fatalf("expected to be inside a code fence")
+	}
+	if want := "Some text\n```go\nfunc main() {\n\n"; sp.block.String() != want {
+		t.Fatalf("want block %q, got %q", want, sp.block.String())
+	}
+	if out.Len() != printed {
+		t.Fatalf("expected nothing printed inside a code fence, got %q", out.String()[printed:])
+	}
+
+	// Closing the fence lets the next blank line print the whole block
+	sp.write("}\n```\n\n")
+	if sp.inFence {
+		t.Fatalf("expected the code fence to be closed")
+	}
+	if sp.block.Len() != 0 {
+		t.Fatalf("want an empty block, got %q", sp.block.String())
+	}
+	if want := rendered(t, "Some text\n```go\nfunc main() {\n\n}\n```\n\n"); out.String()[printed:] != want {
+		t.Fatalf("want the paragraph and code block printed together, got %q", out.String()[printed:])
+	}
+	printed = out.Len()
+
+	// Flushing prints the rest, even without a trailing newline
+	sp.write("The end")
+	sp.flush()
+	if want := rendered(t, "The end"); sp.pending != "" || out.String()[printed:] != want {
+		t.Fatalf("want the remaining text printed, got %q", out.String()[printed:])
+	}
+}
+
+func TestStreamPrinterRaw(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	sp := &streamPrinter{out: &out, raw: true}
+
+	sp.write("# Tit")
+	sp.write("le\n\n```\n")
+	sp.flush()
+	if want := "# Title\n\n```\n"; out.String() != want {
+		t.Fatalf("want %q, got %q", want, out.String())
+	}
+}
+
+func TestGenerateWithStreamingInterrupted(t *testing.T) {
+	// Interrupt the spinner once the first chunk arrives, as pressing ctrl+c does
+	runSpinner = func(cancel context.CancelFunc, action func()) error {
+		action()
+		cancel()
+		return errInterrupted
+	}
+	t.Cleanup(func() { runSpinner = createSpinner })
+
+	finished := make(chan struct{})
+	generate := func(ctx context.Context, pc assembllm.CompletionPluginConfig) (string, error) {
+		defer close(finished)
+		for _, chunk := range []string{"one", "two", "three"} {
+			pc.OnChunk(chunk)
+		}
+		return "", ctx.Err()
+	}
+
+	_, _, err := generateWithStreaming(context.Background(), assembllm.CompletionPluginConfig{}, true, generate)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("want %v, got %v", errInterrupted, err)
+	}
+
+	// The plugin's remaining chunks don't block it from finishing
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the interrupted call to finish")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+	"github.com/spf13/cobra"
+)
+
+// Totals the token usage reported during a run by plugin and model
+type usageTotals struct {
+	mu      sync.Mutex
+	entries map[string]*assembllm.Usage
+}
+
+var runUsage = &usageTotals{}
+
+func (t *usageTotals) add(u assembllm.Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		t.entries = map[string]*assembllm.Usage{}
+	}
+	key := u.Plugin + "/" + u.Model
+	if t.entries[key] == nil {
+		t.entries[key] = &assembllm.Usage{}
+	}
+	t.entries[key].Add(u)
+}
+
+// Gets the totals for each plugin and model, sorted by plugin and model
+func (t *usageTotals) list() []assembllm.Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var list []assembllm.Usage
+	for _, u := range t.entries {
+		list = append(list, *u)
+	}
+	sortUsage(list)
+	return list
+}
+
+// Gets the combined usage of every plugin and model
+func (t *usageTotals) total() assembllm.Usage {
+	var total assembllm.Usage
+	for _, u := range t.list() {
+		total.InputTokens += u.InputTokens
+		total.OutputTokens += u.OutputTokens
+		total.Cost += u.Cost
+	}
+	return total
+}
+
+// Gets the combined usage, or nil when no tokens were reported
+func (t *usageTotals) reported() *assembllm.Usage {
+	total := t.total()
+	if total.InputTokens == 0 && total.OutputTokens == 0 {
+		return nil
+	}
+	return &total
+}
+
+func sortUsage(list []assembllm.Usage) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Plugin != list[j].Plugin {
+			return list[i].Plugin < list[j].Plugin
+		}
+		return list[i].Model < list[j].Model
+	})
+}
+
+// Usage since an earlier total, or nil when no tokens were reported
+func usageSince(before assembllm.Usage) *assembllm.Usage {
+	after := runUsage.total()
+	delta := assembllm.Usage{
+		InputTokens:  after.InputTokens - before.InputTokens,
+		OutputTokens: after.OutputTokens - before.OutputTokens,
+		Cost:         after.Cost - before.Cost,
+	}
+	if delta.InputTokens == 0 && delta.OutputTokens == 0 {
+		return nil
+	}
+	return &delta
+}
+
+// Prints a table of token usage and cost by plugin and model
+func printUsage(w io.Writer, list []assembllm.Usage) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLUGIN\tMODEL\tINPUT\tOUTPUT\tCOST")
+
+	var total assembllm.Usage
+	for _, u := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t$%.4f\n", u.Plugin, u.Model, u.InputTokens, u.OutputTokens, u.Cost)
+		total.InputTokens += u.InputTokens
+		total.OutputTokens += u.OutputTokens
+		total.Cost += u.Cost
+	}
+
+	fmt.Fprintf(tw, "total\t\t%d\t%d\t$%.4f\n", total.InputTokens, total.OutputTokens, total.Cost)
+	tw.Flush()
+}
+
+// An entry in the usage ledger, one per plugin and model used in a run
+type ledgerEntry struct {
+	Time time.Time `json:"time"`
+	assembllm.Usage
+	Workflow string `json:"workflow,omitempty"`
+}
+
+func getLedgerPath() string {
+	return filepath.Join(getAppDir(), "usage.jsonl")
+}
+
+// Appends the run's usage to the ledger and prints a summary when requested
+func finishUsage() {
+	list := runUsage.list()
+	if len(list) == 0 {
+		return
+	}
+
+	if err := appendLedger(list, appCfg.WorkflowPath); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing usage ledger: %v\n", err)
+	}
+
+	if appCfg.Usage {
+		printUsage(os.Stderr, list)
+	}
+}
+
+func appendLedger(list []assembllm.Usage, workflow string) error {
+	path := getLedgerPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	enc := json.NewEncoder(f)
+	for _, u := range list {
+		if err := enc.Encode(ledgerEntry{Time: now, Usage: u, Workflow: workflow}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Appends the usage of a single request or tool call to the ledger
+func recordUsage(usage *usageTotals, workflow string) {
+	list := usage.list()
+	if len(list) == 0 {
+		return
+	}
+
+	if err := appendLedger(list, workflow); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing usage ledger: %v\n", err)
+	}
+}
+
+func newLedgerCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "ledger",
+		Short:         "Summarize recorded token usage and cost for a month",
+		Args:          cobra.NoArgs,
+		RunE:          showLedger,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().String("month", time.Now().Format("2006-01"), "Month to summarize, as YYYY-MM")
+
+	return cmd
+}
+
+func showLedger(cmd *cobra.Command, args []string) error {
+	month, _ := cmd.Flags().GetString("month")
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("invalid month: %s, use YYYY-MM", month)
+	}
+
+	list, err := summarizeLedger(getLedgerPath(), month)
+	if err != nil {
+		return err
+	}
+
+	printUsage(os.Stdout, list)
+	return nil
+}
+
+// Totals the usage recorded in the ledger during the month, by plugin and model
+func summarizeLedger(path string, month string) ([]assembllm.Usage, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no usage recorded yet")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	totals := &usageTotals{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error reading usage ledger, line %d: %v", line, err)
+		}
+		if entry.Time.Local().Format("2006-01") == month {
+			totals.add(entry.Usage)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals.list(), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
+)
+
+func TestLedger(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := getLedgerPath()
+	month := time.Now().Format("2006-01")
+
+	if _, err := summarizeLedger(path, month); err == nil || !strings.Contains(err.Error(), "no usage recorded yet") {
+		t.Fatalf("want no usage recorded yet, got %v", err)
+	}
+
+	runs := [][]assembllm.Usage{
+		{
+			{Plugin: "openai", Model: "gpt-4o", InputTokens: 10, OutputTokens: 5, Cost: 0.25},
+			{Plugin: "anthropic", Model: "claude-3-haiku", InputTokens: 3, OutputTokens: 2, Cost: 0.125},
+		},
+		{
+			{Plugin: "openai", Model: "gpt-4o", InputTokens: 20, OutputTokens: 10, Cost: 0.5},
+		},
+	}
+	for _, run := range runs {
+		if err := appendLedger(run, "summarize.yaml"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+
+	// Usage from other months isn't included
+	old, err := json.Marshal(ledgerEntry{Time: time.Now().AddDate(-1, 0, 0), Usage: assembllm.Usage{Plugin: "openai", Model: "gpt-4o", InputTokens: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(append(old, '\n'))
+	f.Close()
+
+	got, err := summarizeLedger(path, month)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	want := []assembllm.Usage{
+		{Plugin: "anthropic", Model: "claude-3-haiku", InputTokens: 3, OutputTokens: 2, Cost: 0.125},
+		{Plugin: "openai", Model: "gpt-4o", InputTokens: 30, OutputTokens: 15, Cost: 0.75},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+
+	var out bytes.Buffer
+	printUsage(&out, got)
+	wantTable := `PLUGIN     MODEL           INPUT  OUTPUT  COST
+anthropic  claude-3-haiku  3      2       $0.1250
+openai     gpt-4o          30     15      $0.7500
+total                      33     17      $0.8750
+`
+	if out.String() != wantTable {
+		t.Fatalf("want:\n%s\ngot:\n%s", wantTable, out.String())
+	}
+
+	// A corrupt line is reported rather than skipped, so totals are never silently wrong
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{not json\n")
+	f.Close()
+
+	if _, err := summarizeLedger(path, month); err == nil || !strings.Contains(err.Error(), "error reading usage ledger, line 5") {
+		t.Fatalf("want an error for line 5, got %v", err)
+	}
+}
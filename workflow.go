@@ -1,194 +1,323 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/bradyjoslin/assembllm/pkg/assembllm"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/huh"
-	"github.com/charmbracelet/huh/spinner"
-	"github.com/charmbracelet/lipgloss"
-	"github.com/expr-lang/expr"
-	"gopkg.in/yaml.v3"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
 )
 
-type Tasks struct {
-	IterationValuesIn string `yaml:"iterator_script"`
-	IterationValues   []interface{}
-	Tasks             []Task `yaml:"tasks"`
-}
+var (
+	// Workflow names that can be run by name, so requests can't reach files outside the workflow directory
+	workflowNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
 
-type Task struct {
-	Name        string `yaml:"name"`
-	Prompt      string `yaml:"prompt"`
-	Role        string `yaml:"role"`
-	Plugin      string `yaml:"plugin"`
-	Model       string `yaml:"model"`
-	Temperature string `yaml:"temperature"`
-	PreScript   string `yaml:"pre_script"`
-	PostScript  string `yaml:"post_script"`
-	Tools       []Tool `yaml:"tools,omitempty"`
-}
+	workflowExtensions = []string{".yaml", ".yml"}
+)
 
-func getAbsolutePath(path string) (string, error) {
-	workflowDir := filepath.Dir(appCfg.WorkflowPath)
-	joinedPath := filepath.Join(workflowDir, path)
-	return filepath.Abs(joinedPath)
-}
+func handleTasks(ctx context.Context, prompt string) error {
+	start := time.Now()
 
-func workflowChain(path string, p string) (string, error) {
-	absPath, err := getAbsolutePath(path)
+	client, err := newClient()
 	if err != nil {
-		return "", fmt.Errorf("error loading workflow, check filepath: %v", err)
+		return configError(err)
 	}
 
-	res, err := exec.Command("assembllm", "--raw", "-w", absPath, p).Output()
+	workflow, err := client.LoadWorkflowFile(appCfg.WorkflowPath)
 	if err != nil {
-		return "", fmt.Errorf("error loading workflow: %v\n%v\n%v", absPath, string(res), err)
+		return configError(err)
 	}
-	return string(res), nil
-}
 
-func generateResponseForTasks(tasks Tasks) (string, error) {
-	var out string
+	if err := setWorkflowInputs(workflow); err != nil {
+		return err
+	}
 
-	for _, task := range tasks.Tasks {
+	runCtx, cancel, err := workflow.WithTimeout(ctx)
+	if err != nil {
+		return configError(err)
+	}
+	defer cancel()
 
-		if task.PreScript != "" {
-			s, err := runExpr(task.Prompt, task.PreScript)
-			if err != nil {
-				return "", err
-			}
-			task.Prompt = task.Prompt + s
-		}
+	timeoutErr := workflowTimeoutError(runCtx, workflow)
+
+	iterationValues, err := workflow.Iterations(runCtx, prompt)
+	if err != nil {
+		return runError(timeoutErr(err))
+	}
 
-		var res string
-		if task.Plugin != "" {
-			pluginCfg, err := getPluginConfig(task.Plugin, getConfigPath())
+	results := workflow.StartIterations(runCtx, prompt, iterationValues, appCfg.Parallel)
+	if appCfg.Output != "" {
+		return writeWorkflowRecords(appCfg.WorkflowPath, prompt, workflow.Inputs, iterationValues, results, start, timeoutErr)
+	}
+
+	var res string
+	for _, result := range results {
+		action := func() {
+			res, err = result.Wait()
 			if err != nil {
-				return "", err
+				err = runError(timeoutErr(err))
 			}
-			if task.Temperature != "" {
-				pluginCfg.Temperature = task.Temperature
+			if err == nil && !appCfg.Raw {
+				res, err = glamour.Render(res, "dark")
 			}
+		}
 
-			pluginCfg.Role = task.Role
-			pluginCfg.Model = task.Model
-			prompt := out + task.Prompt
-
-			if task.Tools != nil {
-				res, err = pluginCfg.generateResponseWithTools(prompt, task.Tools)
-				if err != nil {
-					return "", err
-				}
-			} else {
-
-				res, err = pluginCfg.generateResponse(prompt, true)
-				if err != nil {
-					return "", err
-				}
-			}
+		if spinErr := createSpinner(cancel, action); spinErr != nil {
+			return spinErr
 		}
 
-		if task.PostScript != "" {
-			s, err := runExpr(res, task.PostScript)
-			if err != nil {
-				return "", err
-			}
-			res = s
+		if err != nil {
+			return err
 		}
 
-		out = res
+		fmt.Print(res)
 	}
 
-	if !appCfg.Raw {
-		return glamour.Render(out, "dark")
+	if appCfg.Feedback {
+		var rerun bool
+		huh.NewConfirm().Title("Would you like to provide feedback and rerun the workflow?").Value(&rerun).Run()
+
+		if rerun {
+			var feedback string
+			huh.NewInput().Title("Provide your feedback or follow-up question:").Value(&feedback).Run()
+			return handleTasks(ctx, "you were prompted with "+prompt+"and responded with "+res+" the user provided this feedback: "+feedback)
+		}
 	}
-	return out, nil
+
+	return nil
 }
 
-func handleTasks(prompt string) error {
-	tasksCfg, err := os.ReadFile(appCfg.WorkflowPath)
-	if err != nil {
+// Reports the workflow's timeout rather than the error it caused in whichever task was running
+func workflowTimeoutError(runCtx context.Context, workflow *assembllm.Workflow) func(error) error {
+	return func(err error) error {
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("workflow %w after %s", assembllm.ErrTimeout, workflow.Tasks.Timeout)
+		}
 		return err
 	}
+}
 
-	var tasks Tasks
-	err = yaml.Unmarshal(tasksCfg, &tasks)
-	if err != nil {
-		return err
+// Sets the workflow's inputs from the input file and --set flags, asking for required inputs that are missing
+// Values that were asked for are kept in appCfg.Inputs, so a rerun with feedback doesn't ask again
+func setWorkflowInputs(workflow *assembllm.Workflow) error {
+	values := map[string]interface{}{}
+	if appCfg.InputFile != "" {
+		data, err := os.ReadFile(expandHome(appCfg.InputFile))
+		if err != nil {
+			return configError(fmt.Errorf("unable to read input file: %v", err))
+		}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return configError(fmt.Errorf("invalid input file %s: %v", appCfg.InputFile, err))
+		}
 	}
 
-	if tasks.IterationValuesIn == "" {
-		tasks.IterationValues = []interface{}{nil}
-	} else {
-		env := map[string]interface{}{
-			"input":      prompt,
-			"Get":        httpGet,
-			"AppendFile": appendFile,
-			"ReadFile":   readfile,
-			"Extism":     callExtismPlugin,
-			"Resend":     resend,
+	for _, set := range appCfg.Inputs {
+		name, value, ok := strings.Cut(set, "=")
+		if !ok || name == "" {
+			return configError(fmt.Errorf("invalid --set %q, use name=value", set))
 		}
+		values[name] = value
+	}
 
-		program, err := expr.Compile(tasks.IterationValuesIn, expr.Env(env), expr.AsKind(reflect.Slice))
+	if missing := workflow.MissingInputs(values); len(missing) > 0 && isTerminal(os.Stdin) {
+		asked, err := askInputs(missing)
 		if err != nil {
-			return err
+			return configError(err)
+		}
+		for name, value := range asked {
+			values[name] = value
+			appCfg.Inputs = append(appCfg.Inputs, name+"="+value)
 		}
+	}
 
-		output, err := expr.Run(program, env)
-		if err != nil {
-			return err
+	if err := workflow.SetInputs(values); err != nil {
+		return configError(err)
+	}
+	return nil
+}
+
+// Prompts for the values of inputs, checking each against the input's type
+func askInputs(inputs []assembllm.Input) (map[string]string, error) {
+	values := make([]string, len(inputs))
+	var fields []huh.Field
+	for i, in := range inputs {
+		title := in.Name
+		if in.Kind() != "string" {
+			title += " (" + in.Kind() + ")"
 		}
+		fields = append(fields, huh.NewInput().
+			Title(title).
+			Description(in.Description).
+			Value(&values[i]).
+			Validate(func(s string) error {
+				_, err := in.Convert(s)
+				return err
+			}))
+	}
 
-		tasks.IterationValues = output.([]interface{})
+	err := huh.NewForm(huh.NewGroup(fields...)).WithTheme(huh.ThemeCharm()).Run()
+	if err != nil {
+		return nil, fmt.Errorf("error getting inputs: %v", err)
 	}
 
-	var res string
-	for i := range tasks.IterationValues {
-		appCfg.CurrentIterationValue = tasks.IterationValues[i]
+	asked := map[string]string{}
+	for i, in := range inputs {
+		asked[in.Name] = values[i]
+	}
+	return asked, nil
+}
 
-		if len(tasks.Tasks) > 0 {
-			if prompt != "" {
-				tasks.Tasks[0].Prompt = prompt + " " + tasks.Tasks[0].Prompt
-			}
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Runs a workflow file without printing, returning its record and recording its usage in the ledger
+// The client is a copy, so each run totals its own usage
+func runWorkflowFile(ctx context.Context, client assembllm.Client, name string, path string, input string, inputs map[string]interface{}) (workflowRecord, error) {
+	usage := &usageTotals{}
+	client.OnUsage = usage.add
+	defer recordUsage(usage, path)
+
+	// Workflows run for other programs are validated first, so mistakes are reported rather than run
+	diagnostics, err := client.ValidateWorkflowFile(path)
+	if err != nil {
+		return workflowRecord{}, configError(fmt.Errorf("workflow %s: %v", name, err))
+	}
+	if len(diagnostics) > 0 {
+		var problems []string
+		for _, d := range diagnostics {
+			problems = append(problems, d.String())
 		}
+		return workflowRecord{}, configError(fmt.Errorf("workflow %s is invalid: %s", name, strings.Join(problems, "; ")))
+	}
 
-		action := func(tasks Tasks) {
-			res, err = generateResponseForTasks(tasks)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+	start := time.Now()
+	workflow, err := client.LoadWorkflowFile(path)
+	if err != nil {
+		return workflowRecord{}, configError(fmt.Errorf("workflow %s: %v", name, err))
+	}
+	if err := workflow.SetInputs(inputs); err != nil {
+		return workflowRecord{}, configError(fmt.Errorf("workflow %s: %w", name, err))
+	}
+
+	runCtx, cancel, err := workflow.WithTimeout(ctx)
+	if err != nil {
+		return workflowRecord{}, configError(fmt.Errorf("workflow %s: %v", name, err))
+	}
+	defer cancel()
+	timeoutErr := workflowTimeoutError(runCtx, workflow)
+
+	values, err := workflow.Iterations(runCtx, input)
+	if err != nil {
+		return workflowRecord{}, runError(timeoutErr(err))
+	}
+
+	record := workflowRecord{Type: "workflow", Workflow: name, Input: input, Inputs: workflow.Inputs}
+	for i, result := range workflow.StartIterations(runCtx, input, values, 0) {
+		res, err := result.Wait()
+		if err != nil {
+			return workflowRecord{}, runError(timeoutErr(err))
 		}
+		record.Iterations = append(record.Iterations, newIterationRecord(i, values[i], result, res, nil))
+	}
 
-		_ = spinner.New().
-			Title("Generating...").
-			TitleStyle(lipgloss.NewStyle().Faint(true)).
-			Action(func() { action(tasks) }).
-			Run()
+	record.Usage = usage.reported()
+	record.DurationMs = time.Since(start).Milliseconds()
+	return record, nil
+}
 
-		fmt.Print(res)
+// Gets the path of the named workflow in a directory of workflows
+func findWorkflow(dir string, name string) (string, bool) {
+	if !workflowNameRegex.MatchString(name) {
+		return "", false
 	}
 
-	if appCfg.Feedback {
-		var rerun bool
-		huh.NewConfirm().Title("Would you like to provide feedback and rerun the workflow?").Value(&rerun).Run()
+	for _, ext := range workflowExtensions {
+		path := filepath.Join(dir, name+ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
 
-		if rerun {
-			var feedback string
-			huh.NewInput().Title("Provide your feedback or follow-up question:").Value(&feedback).Run()
-			return handleTasks("you were prompted with " + prompt + "and responded with " + res + " the user provided this feedback: " + feedback)
+	return "", false
+}
+
+// Gets the names of the workflows in a directory, sorted
+func listWorkflows(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		name := strings.TrimSuffix(e.Name(), ext)
+		if e.IsDir() || !slices.Contains(workflowExtensions, ext) || !workflowNameRegex.MatchString(name) || slices.Contains(names, name) {
+			continue
 		}
+		names = append(names, name)
 	}
 
-	return nil
+	return names, nil
 }
 
-func executeWorkflow(args []string) error {
+func executeWorkflow(ctx context.Context, args []string) error {
 	prompt := generatePrompt(args, false)
-	return handleTasks(prompt)
+	return handleTasks(ctx, prompt)
+}
+
+func newWorkflowCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workflow",
+		Short: "Work with workflow files",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "validate <file>...",
+		Short:         "Check workflow files for problems without running them",
+		Args:          cobra.MinimumNArgs(1),
+		RunE:          validateWorkflows,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	return cmd
+}
+
+func validateWorkflows(cmd *cobra.Command, args []string) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, path := range args {
+		diagnostics, err := client.ValidateWorkflowFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+		problems += len(diagnostics)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+
+	return nil
 }